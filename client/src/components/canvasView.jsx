@@ -1,6 +1,10 @@
 import React from 'react';
 
 import {Universe, PlayerState} from '../gameObjects';
+import {Connection} from '../protocol';
+
+// the number of chat messages shown
+const CHAT_LINES = 8;
 
 class CanvasView extends React.Component {
     constructor(props) {
@@ -13,6 +17,12 @@ class CanvasView extends React.Component {
             ws: null,
             playerBody: null,
             playerBodyId: null,
+            playback: null,
+            match: null,
+            spectating: null,
+            chat: [],
+            chatInput: '',
+            notice: null,
             isMounted: false,
             host: '127.0.0.1:8080',
             useLocalhost: true,
@@ -35,13 +45,70 @@ class CanvasView extends React.Component {
 
     initWebSocket() {
         const self = this;
-        this.state.ws = new WebSocket(`ws://${this.state.useLocalhost ?
-            this.state.host : window.locaction.host}/game`);
-        this.state.ws.onmessage = function (e) {
-            const data = JSON.parse(e.data);
+        const params = new URLSearchParams(window.location.search);
+        // the session lets a refreshed page take back control of its body
+        const session = JSON.parse(window.sessionStorage.getItem('session') || 'null');
+        // the page's room query parameter picks the room to join, otherwise
+        // the player goes back to their last room or quick play picks one
+        let room = params.get('room');
+        if (!room && session) {
+            room = session.room;
+        }
+        // the name and color parameters are passed along for the player's body
+        const query = new URLSearchParams();
+        for (const key of ['name', 'color']) {
+            if (params.get(key)) {
+                query.set(key, params.get(key));
+            }
+        }
+        let path = '/lobby/quickplay';
+        if (params.has('spectate')) {
+            path = `/game/${encodeURIComponent(room || 'default')}`;
+            query.set('spectate', '1');
+        } else if (room) {
+            path = `/game/${encodeURIComponent(room)}`;
+            if (session && session.room === room) {
+                query.set('session', session.token);
+            }
+        }
+        if (query.toString()) {
+            path += `?${query}`;
+        }
+        this.state.ws = new Connection(`ws://${this.state.useLocalhost ?
+            this.state.host : window.locaction.host}${path}`);
+        this.state.ws.onmessage = function (data) {
+            if (data.Playback) {
+                self.state.playback = data.Playback;
+            }
             if (data.GameState) {
-                self.update(data.GameState.Universe);
-                self.state.ws.send(JSON.stringify(self.state.playerState.render()));
+                const state = self.state.universe.apply(data.GameState);
+                if (state) {
+                    self.update(state);
+                    if (!self.state.playback) {
+                        const input = self.state.playerState.render();
+                        input.Ack = data.GameState.Tick;
+                        self.state.ws.send(input);
+                    }
+                }
+            }
+            if (data.PlayerLeft) {
+                self.showNotice(`Player ${data.PlayerLeft.BodyId} left`);
+            }
+            if (data.SessionToken) {
+                window.sessionStorage.setItem('session', JSON.stringify({
+                    room: data.Room,
+                    token: data.SessionToken,
+                }));
+            }
+            if (data.Match) {
+                self.setState({ match: data.Match });
+            }
+            if (data.Chat) {
+                self.setState({ chat: self.state.chat.concat(data.Chat).slice(-CHAT_LINES) });
+            }
+            if (data.Spectating) {
+                self.state.playerBodyId = data.Spectating.BodyId;
+                self.setState({ spectating: data.Spectating });
             }
             if (data.AssignedBodyId) {
                 self.state.playerState.playerBodyId = data.AssignedBodyId;
@@ -56,6 +123,13 @@ class CanvasView extends React.Component {
         }
     }
 
+    // shows a message in the overlay for a few seconds
+    showNotice(notice) {
+        clearTimeout(this.noticeTimeout);
+        this.noticeTimeout = setTimeout(() => this.setState({ notice: null }), 3000);
+        this.setState({ notice });
+    }
+
     bigBang() {
         this.state.universe = new Universe();
         if (this.state.isMounted) {
@@ -67,7 +141,23 @@ class CanvasView extends React.Component {
     listenForPlayerInput() {
         const self = this;
         function handleUserInput(e) {
+            // keys typed into the chat box aren't for steering
+            if (e.target.tagName === 'INPUT') {
+                return;
+            }
             const val = e.type === 'keydown' ? true : false;
+            if (self.state.playback) {
+                if (val) {
+                    self.handlePlaybackInput(e);
+                }
+                return;
+            }
+            if (self.state.spectating) {
+                if (val) {
+                    self.handleSpectatorInput(e);
+                }
+                return;
+            }
             if (self.state.playerState.playerBodyId === null) {
                 return;
             }
@@ -97,6 +187,68 @@ class CanvasView extends React.Component {
         window.addEventListener('keydown', handleUserInput);
     }
 
+    // space pauses, left and right seek, and up and down change the speed
+    handlePlaybackInput(e) {
+        const playback = this.state.playback;
+        const seekTicks = 5 * 30;
+        let control = null;
+        switch (e.which) {
+        case 32:
+            control = { Paused: !playback.Paused };
+            break;
+        case 37:
+            control = { Seek: Math.max(playback.StartTick, playback.Tick - seekTicks) };
+            break;
+        case 39:
+            control = { Seek: playback.Tick + seekTicks };
+            break;
+        case 38:
+            control = { Speed: playback.Speed * 2 };
+            break;
+        case 40:
+            control = { Speed: playback.Speed / 2 };
+            break;
+        default:
+            return;
+        }
+        this.state.ws.send({ Playback: control });
+        e.preventDefault();
+    }
+
+    // "/t message" goes to the player's team and "/w bodyId message" whispers
+    // to another player. Everything else is global.
+    sendChat(e) {
+        e.preventDefault();
+        const text = this.state.chatInput;
+        let chat = { Channel: 'global', Text: text };
+        let match;
+        if ((match = /^\/t\s+(.*)$/.exec(text))) {
+            chat = { Channel: 'team', Text: match[1] };
+        } else if ((match = /^\/w\s+(\S+)\s+(.*)$/.exec(text))) {
+            chat = { Channel: 'whisper', To: match[1], Text: match[2] };
+        }
+        this.state.ws.send({ Chat: chat });
+        this.setState({ chatInput: '' });
+    }
+
+    // the number keys follow the body with that rank, and left and right
+    // step through the ranks
+    handleSpectatorInput(e) {
+        const rank = this.state.spectating.Rank || 1;
+        let control = null;
+        if (e.which >= 49 && e.which <= 57) {
+            control = { Rank: e.which - 48 };
+        } else if (e.which === 37) {
+            control = { Rank: Math.max(1, rank - 1) };
+        } else if (e.which === 39) {
+            control = { Rank: rank + 1 };
+        } else {
+            return;
+        }
+        this.state.ws.send({ Spectate: control });
+        e.preventDefault();
+    }
+
     update(state) {
         if (!this.state.isMounted || !this.context) {
             return;
@@ -131,12 +283,17 @@ class CanvasView extends React.Component {
 
     render() {
         const body = this.state.playerBody;
+        const playback = this.state.playback;
+        const match = this.state.match;
 
         return (
             <div id="canvas-wrapper">
                 <div id="overlay">
                     {body && (
                         <div>
+                            {body['PlayerName'] && (
+                                <p>Name: {body['PlayerName']}</p>
+                            )}
                             <p>Mass: {body['Mass']}</p>
                             {body['MinorName'] && (
                                 <p>Minor Name: {body['MinorName']}</p>
@@ -146,7 +303,43 @@ class CanvasView extends React.Component {
                             )}
                         </div>
                     )}
-                    {!body && (
+                    {playback && (
+                        <div>
+                            <p>Replay: {playback.Tick - playback.StartTick} / {playback.EndTick - playback.StartTick}</p>
+                            <p>{playback.Paused ? 'Paused' : `Speed: ${playback.Speed}x`}</p>
+                        </div>
+                    )}
+                    {match && match.Status === 'waiting' && (
+                        <p>Waiting for players: {match.Players} / {match.MinPlayers}</p>
+                    )}
+                    {match && match.Status === 'countdown' && (
+                        <p>Starting in {match.Countdown}...</p>
+                    )}
+                    {this.state.spectating && (
+                        <p>Spectating{this.state.spectating.Rank ? ` #${this.state.spectating.Rank}` : ''}</p>
+                    )}
+                    {this.state.chat.map((msg, i) => (
+                        <p key={i}>
+                            {msg.Channel === 'system' ? '' : `[${msg.Channel}] ${msg.FromName || msg.From}: `}
+                            {msg.Text}
+                        </p>
+                    ))}
+                    {!playback && (
+                        <form onSubmit={e => this.sendChat(e)}>
+                            <input type="text"
+                                value={this.state.chatInput}
+                                placeholder="Chat"
+                                onChange={e => this.setState({ chatInput: e.target.value })}
+                            />
+                        </form>
+                    )}
+                    {this.state.ws && this.state.ws.rtt !== null && (
+                        <p>Ping: {Math.round(this.state.ws.rtt)} ms</p>
+                    )}
+                    {this.state.notice && (
+                        <p>{this.state.notice}</p>
+                    )}
+                    {!body && !playback && !this.state.spectating && (
                         <p>Reload to play again.</p>
                     )}
                 </div>
@@ -31,9 +31,45 @@ class Vector {
 
 const GRID_LINE_INTERVAL = 250;
 
+// the number of received states kept around for deltas to be applied to
+const STATE_HISTORY_SIZE = 64;
+
 class Universe {
   constructor() {
     this.state = null;
+    this.history = {};
+  }
+
+  // Applies a game state from the server, which is either a keyframe or a delta
+  // from an earlier state. Returns the resulting state, or null if the state
+  // the delta is based on isn't known.
+  apply(gameState) {
+    var universe = gameState["Universe"];
+    var bodies = {};
+    if (gameState["BaseTick"]) {
+      var base = this.history[gameState["BaseTick"]];
+      if (!base) {
+        return null;
+      }
+      Object.assign(bodies, base["Bodies"]);
+      for (const id of universe["Removed"] || []) {
+        delete bodies[id];
+      }
+    }
+    Object.assign(bodies, universe["Bodies"]);
+
+    var tick = gameState["Tick"];
+    var state = {
+      Bounds: universe["Bounds"],
+      Bodies: bodies,
+    };
+    this.history[tick] = state;
+    for (const t of Object.keys(this.history)) {
+      if (Math.abs(tick - t) >= STATE_HISTORY_SIZE) {
+        delete this.history[t];
+      }
+    }
+    return state;
   }
 
   getBody(id) {
@@ -132,14 +168,14 @@ class Universe {
 
       context.beginPath();
       context.arc(pos.x, pos.y, r, 0, 2 * Math.PI);
-      context.fillStyle = this.color;
+      context.fillStyle = body['Color'] || this.color;
       context.fill();
       context.lineWidth = 5;
       context.strokeStyle = '#003300';
       context.stroke();
       context.textAlign = 'center';
       context.font = fontSize + 'px Arial';
-      context.fillText(body['MajorName'] || body['MinorName'] || '', pos.x, pos.y + r * 2.1);
+      context.fillText(body['PlayerName'] || body['MajorName'] || body['MinorName'] || '', pos.x, pos.y + r * 2.1);
 
       context.lineWidth = 2;
       context.strokeStyle = '#FF00FF';
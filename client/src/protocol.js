@@ -0,0 +1,515 @@
+/*eslint-disable*/
+
+// The binary encoding of the websocket messages. See server/codec.go for the
+// format. Decoded messages have the same shape as the JSON ones.
+
+const BINARY_SUBPROTOCOL = 'grav-binary';
+const JSON_SUBPROTOCOL = 'grav-json';
+
+const WIRE_VARINT = 0;
+const WIRE_FIXED64 = 1;
+const WIRE_BYTES = 2;
+const WIRE_FIXED32 = 5;
+
+class Reader {
+  constructor(view, start, end) {
+    this.view = view;
+    this.pos = start;
+    this.end = end;
+    this.tag = 0;
+    this.wire = 0;
+  }
+
+  // Advances to the next field, returning false at the end of the message.
+  next() {
+    if (this.pos >= this.end) {
+      return false;
+    }
+    var key = this.readVarint();
+    this.tag = Math.floor(key / 8);
+    this.wire = key % 8;
+    return true;
+  }
+
+  // Varints can be 64 bits, so this avoids bitwise operators to keep precision
+  // up to 2^53.
+  readVarint() {
+    var ret = 0;
+    var scale = 1;
+    for (;;) {
+      if (this.pos >= this.end) {
+        throw new Error('invalid varint');
+      }
+      var b = this.view.getUint8(this.pos++);
+      ret += (b & 0x7f) * scale;
+      if (b < 0x80) {
+        return ret;
+      }
+      scale *= 128;
+    }
+  }
+
+  varint() {
+    return this.readVarint();
+  }
+
+  bool() {
+    return this.readVarint() !== 0;
+  }
+
+  float32() {
+    var ret = this.view.getFloat32(this.pos, true);
+    this.pos += 4;
+    return ret;
+  }
+
+  float64() {
+    var ret = this.view.getFloat64(this.pos, true);
+    this.pos += 8;
+    return ret;
+  }
+
+  string() {
+    var n = this.readVarint();
+    var bytes = new Uint8Array(this.view.buffer, this.view.byteOffset + this.pos, n);
+    this.pos += n;
+    return new TextDecoder().decode(bytes);
+  }
+
+  // Reads a nested message, calling f with a reader for each of its fields.
+  message(f) {
+    var n = this.readVarint();
+    var r = new Reader(this.view, this.pos, this.pos + n);
+    while (r.next()) {
+      f(r);
+    }
+    this.pos += n;
+  }
+
+  skip() {
+    switch (this.wire) {
+    case WIRE_VARINT:
+      this.readVarint();
+      break;
+    case WIRE_FIXED64:
+      this.pos += 8;
+      break;
+    case WIRE_BYTES:
+      this.pos += this.readVarint();
+      break;
+    case WIRE_FIXED32:
+      this.pos += 4;
+      break;
+    default:
+      throw new Error('unknown wire type ' + this.wire);
+    }
+  }
+}
+
+class Writer {
+  constructor() {
+    this.bytes = [];
+  }
+
+  key(tag, wire) {
+    this.putVarint(tag * 8 + wire);
+  }
+
+  putVarint(v) {
+    while (v >= 0x80) {
+      this.bytes.push((v % 128) | 0x80);
+      v = Math.floor(v / 128);
+    }
+    this.bytes.push(v);
+  }
+
+  varint(tag, v) {
+    this.key(tag, WIRE_VARINT);
+    this.putVarint(v);
+  }
+
+  bool(tag, v) {
+    this.varint(tag, v ? 1 : 0);
+  }
+
+  float64(tag, v) {
+    this.key(tag, WIRE_FIXED64);
+    var buf = new DataView(new ArrayBuffer(8));
+    buf.setFloat64(0, v, true);
+    for (var i = 0; i < 8; i++) {
+      this.bytes.push(buf.getUint8(i));
+    }
+  }
+
+  string(tag, v) {
+    var bytes = new TextEncoder().encode(v);
+    this.key(tag, WIRE_BYTES);
+    this.putVarint(bytes.length);
+    this.bytes.push(...bytes);
+  }
+
+  message(tag, f) {
+    var w = new Writer();
+    f(w);
+    this.key(tag, WIRE_BYTES);
+    this.putVarint(w.bytes.length);
+    this.bytes.push(...w.bytes);
+  }
+}
+
+function decodeBody(body, r) {
+  switch (r.tag) {
+  case 2: body.MinorName = r.string(); break;
+  case 3: body.MajorName = r.string(); break;
+  case 4: body.Position.X = r.float32(); break;
+  case 5: body.Position.Y = r.float32(); break;
+  case 6: body.Mass = r.float32(); break;
+  case 7: body.Radius = r.float32(); break;
+  case 8: body.NetForce.X = r.float32(); break;
+  case 9: body.NetForce.Y = r.float32(); break;
+  case 10: body.PlayerName = r.string(); break;
+  case 11: body.Color = r.string(); break;
+  default: r.skip();
+  }
+}
+
+function decodeGameState(state, r) {
+  switch (r.tag) {
+  case 1:
+    state.Tick = r.varint();
+    break;
+  case 2:
+    state.BaseTick = r.varint();
+    break;
+  case 3:
+    var bounds = state.Universe.Bounds;
+    r.message(function (r) {
+      switch (r.tag) {
+      case 1: bounds.X = r.float64(); break;
+      case 2: bounds.Y = r.float64(); break;
+      case 3: bounds.W = r.float64(); break;
+      case 4: bounds.H = r.float64(); break;
+      default: r.skip();
+      }
+    });
+    break;
+  case 4:
+    var id = '';
+    var body = {
+      Position: {X: 0, Y: 0},
+      Mass: 0,
+      Radius: 0,
+      NetForce: {X: 0, Y: 0},
+    };
+    r.message(function (r) {
+      if (r.tag === 1) {
+        id = r.string();
+      } else {
+        decodeBody(body, r);
+      }
+    });
+    state.Universe.Bodies[id] = body;
+    break;
+  case 5:
+    state.Universe.Removed.push(r.string());
+    break;
+  case 6:
+    state.InputSeq = r.varint();
+    break;
+  default:
+    r.skip();
+  }
+}
+
+function decodeMatchState(state, r) {
+  switch (r.tag) {
+  case 1: state.Status = r.string(); break;
+  case 2: state.Players = r.varint(); break;
+  case 3: state.MinPlayers = r.varint(); break;
+  case 4: state.Countdown = r.varint(); break;
+  default: r.skip();
+  }
+}
+
+function decodeChatMessage(msg, r) {
+  switch (r.tag) {
+  case 1: msg.Channel = r.string(); break;
+  case 2: msg.From = r.string(); break;
+  case 3: msg.FromName = r.string(); break;
+  case 4: msg.To = r.string(); break;
+  case 5: msg.Team = r.string(); break;
+  case 6: msg.Text = r.string(); break;
+  default: r.skip();
+  }
+}
+
+function decodePing(r) {
+  var ping = {Time: 0};
+  r.message(function (r) {
+    if (r.tag === 1) {
+      ping.Time = r.float64();
+    } else {
+      r.skip();
+    }
+  });
+  return ping;
+}
+
+function decodePong(r) {
+  var pong = {PingTime: 0, Time: 0};
+  r.message(function (r) {
+    switch (r.tag) {
+    case 1: pong.PingTime = r.float64(); break;
+    case 2: pong.Time = r.float64(); break;
+    default: r.skip();
+    }
+  });
+  return pong;
+}
+
+function decodePlaybackState(state, r) {
+  switch (r.tag) {
+  case 1: state.Tick = r.varint(); break;
+  case 2: state.StartTick = r.varint(); break;
+  case 3: state.EndTick = r.varint(); break;
+  case 4: state.Paused = r.bool(); break;
+  case 5: state.Speed = r.float64(); break;
+  default: r.skip();
+  }
+}
+
+// Decodes a binary WebSocketOutput from an ArrayBuffer.
+function decodeOutput(buffer) {
+  var msg = {};
+  var r = new Reader(new DataView(buffer), 0, buffer.byteLength);
+  while (r.next()) {
+    switch (r.tag) {
+    case 1:
+      var state = {
+        Tick: 0,
+        Universe: {
+          Bounds: {X: 0, Y: 0, W: 0, H: 0},
+          Bodies: {},
+          Removed: [],
+        },
+      };
+      r.message(function (r) {
+        decodeGameState(state, r);
+      });
+      msg.GameState = state;
+      break;
+    case 2:
+      msg.AssignedBodyId = r.string();
+      break;
+    case 3:
+      var playback = {Tick: 0, StartTick: 0, EndTick: 0, Paused: false, Speed: 0};
+      r.message(function (r) {
+        decodePlaybackState(playback, r);
+      });
+      msg.Playback = playback;
+      break;
+    case 4:
+      var playerLeft = {BodyId: ''};
+      r.message(function (r) {
+        if (r.tag === 1) {
+          playerLeft.BodyId = r.string();
+        } else {
+          r.skip();
+        }
+      });
+      msg.PlayerLeft = playerLeft;
+      break;
+    case 5:
+      msg.SessionToken = r.string();
+      break;
+    case 6:
+      var match = {Status: '', Players: 0, MinPlayers: 0, Countdown: 0};
+      r.message(function (r) {
+        decodeMatchState(match, r);
+      });
+      msg.Match = match;
+      break;
+    case 7:
+      msg.Room = r.string();
+      break;
+    case 8:
+      var spectating = {BodyId: '', Rank: 0};
+      r.message(function (r) {
+        switch (r.tag) {
+        case 1: spectating.BodyId = r.string(); break;
+        case 2: spectating.Rank = r.varint(); break;
+        default: r.skip();
+        }
+      });
+      msg.Spectating = spectating;
+      break;
+    case 9:
+      var chat = {Channel: '', Text: ''};
+      r.message(function (r) {
+        decodeChatMessage(chat, r);
+      });
+      msg.Chat = (msg.Chat || []).concat([chat]);
+      break;
+    case 10:
+      msg.Ping = decodePing(r);
+      break;
+    case 11:
+      msg.Pong = decodePong(r);
+      break;
+    default:
+      r.skip();
+    }
+  }
+  return msg;
+}
+
+// Encodes a WebSocketInput, returning a Uint8Array.
+function encodeInput(msg) {
+  var w = new Writer();
+  if (msg.Thrust) {
+    w.message(1, function (w) {
+      w.float64(1, msg.Thrust.x || msg.Thrust.X || 0);
+      w.float64(2, msg.Thrust.y || msg.Thrust.Y || 0);
+    });
+  }
+  if (msg.Ack) {
+    w.varint(2, msg.Ack);
+  }
+  if (msg.Seq) {
+    w.varint(6, msg.Seq);
+  }
+  if (msg.Ping) {
+    var ping = msg.Ping;
+    w.message(7, function (w) {
+      w.float64(1, ping.Time);
+    });
+  }
+  if (msg.Pong) {
+    var pong = msg.Pong;
+    w.message(8, function (w) {
+      w.float64(1, pong.PingTime);
+      w.float64(2, pong.Time);
+    });
+  }
+  if (msg.Playback) {
+    var control = msg.Playback;
+    w.message(3, function (w) {
+      if (control.Paused !== undefined) {
+        w.bool(1, control.Paused);
+      }
+      if (control.Speed !== undefined) {
+        w.float64(2, control.Speed);
+      }
+      if (control.Seek !== undefined) {
+        w.varint(3, control.Seek);
+      }
+    });
+  }
+  if (msg.Spectate) {
+    var spectate = msg.Spectate;
+    w.message(4, function (w) {
+      if (spectate.BodyId) {
+        w.string(1, spectate.BodyId);
+      }
+      if (spectate.Name) {
+        w.string(2, spectate.Name);
+      }
+      if (spectate.Rank) {
+        w.varint(3, spectate.Rank);
+      }
+    });
+  }
+  if (msg.Chat) {
+    var chat = msg.Chat;
+    w.message(5, function (w) {
+      w.string(1, chat.Channel);
+      if (chat.To) {
+        w.string(2, chat.To);
+      }
+      w.string(3, chat.Text);
+    });
+  }
+  return new Uint8Array(w.bytes);
+}
+
+const PING_INTERVAL = 2000;
+
+// each ping moves the smoothed round trip time and clock offset this fraction
+// of the way towards the new measurement
+const CLOCK_SMOOTHING = 0.125;
+
+// Wraps a websocket so that messages are encoded with whichever protocol the
+// server picked. Every input is numbered so that it can be matched up with the
+// InputSeq of the game states the server sends back.
+//
+// The connection also pings the server to keep track of the round trip time
+// and how far the server's clock is ahead of this one, both in milliseconds.
+class Connection {
+  constructor(url) {
+    this.ws = new WebSocket(url, [BINARY_SUBPROTOCOL, JSON_SUBPROTOCOL]);
+    this.ws.binaryType = 'arraybuffer';
+    this.seq = 0;
+    this.rtt = null;
+    this.clockOffset = null;
+
+    const self = this;
+    this.ws.onopen = function () {
+      self.pingInterval = setInterval(function () {
+        self.send({ Ping: { Time: Date.now() } });
+      }, PING_INTERVAL);
+    };
+    this.ws.onclose = function () {
+      clearInterval(self.pingInterval);
+    };
+  }
+
+  set onmessage(f) {
+    const self = this;
+    this.ws.onmessage = function (e) {
+      const msg = typeof e.data === 'string' ? JSON.parse(e.data) : decodeOutput(e.data);
+      if (msg.Ping) {
+        self.send({ Pong: { PingTime: msg.Ping.Time, Time: Date.now() } });
+      }
+      if (msg.Pong) {
+        self.handlePong(msg.Pong);
+      }
+      f(msg);
+    };
+  }
+
+  handlePong(pong) {
+    const now = Date.now();
+    const rtt = now - pong.PingTime;
+    // assume the trip took as long each way
+    const offset = pong.Time + rtt / 2 - now;
+    if (this.rtt === null) {
+      this.rtt = rtt;
+      this.clockOffset = offset;
+    } else {
+      this.rtt += (rtt - this.rtt) * CLOCK_SMOOTHING;
+      this.clockOffset += (offset - this.clockOffset) * CLOCK_SMOOTHING;
+    }
+  }
+
+  // Returns the current time by the server's clock.
+  serverTime() {
+    return Date.now() + (this.clockOffset || 0);
+  }
+
+  set onerror(f) {
+    this.ws.onerror = f;
+  }
+
+  // Sends an input, returning its sequence number.
+  send(msg) {
+    msg.Seq = ++this.seq;
+    if (this.ws.protocol === BINARY_SUBPROTOCOL) {
+      this.ws.send(encodeInput(msg));
+    } else {
+      this.ws.send(JSON.stringify(msg));
+    }
+    return msg.Seq;
+  }
+}
+
+export { Connection, decodeOutput, encodeInput, };
@@ -21,8 +21,13 @@ type Body struct {
 	Static             bool
 	Velocity           Vector
 	GravitationalForce Vector
-	Thrust             Vector
-	NetForce           Vector
+	// The direction the body is thrusting in, as a unit vector, or zero if
+	// it isn't.
+	ThrustDirection Vector
+	// The force of the body's thrust. It's worked out from the direction and
+	// the universe's physics at the start of each step.
+	Thrust   Vector
+	NetForce Vector
 }
 
 func (b *Body) Step(d time.Duration) {
@@ -33,20 +38,28 @@ func (b *Body) Step(d time.Duration) {
 }
 
 func (b *Body) Decay(pct float64) {
+	b.decay(pct, minDecayMass)
+}
+
+func (b *Body) decay(pct, minMass float64) {
 	qty := b.Mass * pct
-	if b.Mass >= minDecayMass {
+	if b.Mass >= minMass {
 		b.Mass -= math.Min(qty, b.Mass)
 	}
 }
 
 func (b *Body) ForceDecay(pct float64) {
+	b.forceDecay(pct, minDecayMass, minDecayMassForced)
+}
+
+func (b *Body) forceDecay(pct, minMass, minForcedMass float64) {
 	// TODO: this should be cleaned up
 	qty := b.Mass * pct
-	if b.Mass >= minDecayMass {
+	if b.Mass >= minMass {
 		b.Mass -= math.Min(qty, b.Mass)
 	} else {
 		b.Mass -= math.Min(
-			math.Max(minDecayMass, minDecayMassForced),
+			math.Max(minMass, minForcedMass),
 			b.Mass)
 	}
 }
@@ -55,6 +68,10 @@ func (b *Body) CollidesWith(other *Body) bool {
 	return distance(b.Position, other.Position) < b.Radius+other.Radius
 }
 
+func (b *Body) DistanceTo(p Point) float64 {
+	return distance(b.Position, p)
+}
+
 func (b *Body) MergeWith(other *Body) {
 	// don't conserve velocity against static bodies
 	if !b.Static && !other.Static {
@@ -70,11 +87,17 @@ func (b *Body) MergeWith(other *Body) {
 	other.Mass = 0
 }
 
+// GravitationalForceTo returns the force pulling the body toward other, using
+// the default gravitational constant.
 func (b *Body) GravitationalForceTo(other *Body) Vector {
+	return b.gravitationalForceTo(other, gravitationalConstant)
+}
+
+func (b *Body) gravitationalForceTo(other *Body, g float64) Vector {
 	if b.Static || other.Static {
 		return Vector{}
 	}
-	force := gravitationalConstant * b.Mass * other.Mass / distanceSquared(b.Position, other.Position)
+	force := g * b.Mass * other.Mass / distanceSquared(b.Position, other.Position)
 	return b.Position.VectorTo(other.Position).WithMagnitude(force)
 }
 
@@ -86,6 +109,14 @@ func (b *Body) updateNetForce(d time.Duration) {
 	b.NetForce = b.GravitationalForce.Add(b.Thrust)
 }
 
+// Returns the acceleration due to gravity and thrust.
+func (b *Body) acceleration() Vector {
+	if b.Mass == 0 {
+		panic("acceleration called on zero-mass body")
+	}
+	return b.GravitationalForce.Add(b.Thrust).Scale(1 / b.Mass)
+}
+
 func (b *Body) updateVelocity(d time.Duration) {
 	if b.Static {
 		return
@@ -105,12 +136,14 @@ func (b *Body) updatePosition(d time.Duration) {
 	b.Position.Y += b.Velocity.Y * d.Seconds()
 }
 
-func (b *Body) ThrustEvent(t Vector) func() {
-	return func() {
-		if t.MagnitudeSquared() == 0.0 {
-			b.Thrust = t
-		} else {
-			b.Thrust = t.WithMagnitude(thrustBaseMagnitude)
-		}
+func (b *Body) setThrustDirection(t Vector) {
+	if t.MagnitudeSquared() == 0.0 {
+		b.ThrustDirection = Vector{}
+	} else {
+		b.ThrustDirection = t.WithMagnitude(1)
 	}
 }
+
+func (b *Body) updateThrust(magnitude float64) {
+	b.Thrust = b.ThrustDirection.Scale(magnitude)
+}
@@ -109,9 +109,14 @@ func TestGravitationalForceTo(t *testing.T) {
 	assert.Equal(t, Vector{0, 0}, b3.GravitationalForceTo(&b1))
 }
 
-func TestThrustEvent(t *testing.T) {
+func TestThrustDirection(t *testing.T) {
 	b := Body{}
-	assert.Equal(t, Vector{0, 0}, b.Thrust)
-	b.ThrustEvent(North)()
+	b.setThrustDirection(Vector{0, 5})
+	assert.Equal(t, North, b.ThrustDirection)
+	b.updateThrust(thrustBaseMagnitude)
 	assert.Equal(t, Vector{0, thrustBaseMagnitude}, b.Thrust)
+
+	b.setThrustDirection(Vector{})
+	b.updateThrust(thrustBaseMagnitude)
+	assert.Equal(t, Vector{}, b.Thrust)
 }
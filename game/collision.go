@@ -0,0 +1,164 @@
+package game
+
+import (
+	"math"
+	"sort"
+)
+
+type cell struct {
+	X int
+	Y int
+}
+
+// spatialHash buckets bodies into a uniform grid so that collision candidates
+// can be found without comparing every pair. Each body is added to every cell
+// that its bounding box overlaps, so two bodies can only collide if they share
+// a cell.
+type spatialHash struct {
+	cellSize float64
+	cells    map[cell][]int
+	// Used to avoid returning a body more than once when it's in more than
+	// one of the queried cells.
+	seen  []int
+	query int
+}
+
+// Rebuilds the hash for the given bodies. Indices returned by candidates are
+// indices into bodies.
+func (h *spatialHash) build(bodies []*Body) {
+	maxRadius, totalRadius := 0.0, 0.0
+	for _, b := range bodies {
+		maxRadius = math.Max(maxRadius, b.Radius)
+		totalRadius += b.Radius
+	}
+
+	// Cells should be around the size of a typical body. A few very large
+	// bodies would otherwise end up in a huge number of cells, so the size is
+	// bumped up to keep that in check.
+	h.cellSize = 1.0
+	if len(bodies) > 0 {
+		h.cellSize = math.Max(h.cellSize, 2*totalRadius/float64(len(bodies)))
+	}
+	h.cellSize = math.Max(h.cellSize, maxRadius/4)
+
+	if h.cells == nil || len(h.cells) > 4*len(bodies) {
+		h.cells = make(map[cell][]int)
+	}
+	for k, v := range h.cells {
+		h.cells[k] = v[:0]
+	}
+
+	if cap(h.seen) < len(bodies) {
+		h.seen = make([]int, len(bodies))
+		h.query = 0
+	}
+	h.seen = h.seen[:len(bodies)]
+
+	for i, b := range bodies {
+		h.add(i, b)
+	}
+}
+
+// Adds bodies[i] to every cell it overlaps. Bodies that have moved or grown
+// can be added again, and they'll be found in both places.
+func (h *spatialHash) add(i int, b *Body) {
+	min, max := h.cellRange(b.Position, b.Radius)
+	for x := min.X; x <= max.X; x++ {
+		for y := min.Y; y <= max.Y; y++ {
+			c := cell{x, y}
+			h.cells[c] = append(h.cells[c], i)
+		}
+	}
+}
+
+func (h *spatialHash) cellRange(p Point, r float64) (cell, cell) {
+	return cell{
+		X: int(math.Floor((p.X - r) / h.cellSize)),
+		Y: int(math.Floor((p.Y - r) / h.cellSize)),
+	}, cell{
+		X: int(math.Floor((p.X + r) / h.cellSize)),
+		Y: int(math.Floor((p.Y + r) / h.cellSize)),
+	}
+}
+
+// Appends the indices of every body that might be within r of p to buf, in
+// ascending order.
+func (h *spatialHash) candidates(p Point, r float64, buf []int) []int {
+	h.query++
+	min, max := h.cellRange(p, r)
+	for x := min.X; x <= max.X; x++ {
+		for y := min.Y; y <= max.Y; y++ {
+			for _, i := range h.cells[cell{x, y}] {
+				if h.seen[i] != h.query {
+					h.seen[i] = h.query
+					buf = append(buf, i)
+				}
+			}
+		}
+	}
+	sort.Ints(buf)
+	return buf
+}
+
+// collisionResolver merges colliding bodies in a single pass.
+type collisionResolver struct {
+	hash       spatialHash
+	absorbed   []bool
+	candidates []int
+}
+
+// Merges every pair of colliding bodies until none are left. Bodies are
+// visited in order, and whenever a body absorbs another it's checked again
+// right away, so chains of collisions (A absorbs B, then grows into C) are all
+// resolved in the same pass and always in the same order.
+//
+// When two bodies collide, the heavier one absorbs the lighter one, and ties
+// go to the body that comes first. absorbed is called for each body that's
+// merged into another.
+func (c *collisionResolver) resolve(bodies []*Body, absorbed func(i int)) {
+	c.hash.build(bodies)
+
+	if cap(c.absorbed) < len(bodies) {
+		c.absorbed = make([]bool, len(bodies))
+	}
+	c.absorbed = c.absorbed[:len(bodies)]
+	for i := range c.absorbed {
+		c.absorbed[i] = false
+	}
+
+	for i := range bodies {
+		for current := i; current >= 0 && !c.absorbed[current]; {
+			current = c.mergeFirstCollision(bodies, current, absorbed)
+		}
+	}
+}
+
+// Merges the first body that collides with bodies[i], if any. Returns the
+// index of the merged body, which needs to be checked again, or -1.
+func (c *collisionResolver) mergeFirstCollision(bodies []*Body, i int, absorbed func(i int)) int {
+	body := bodies[i]
+	c.candidates = c.hash.candidates(body.Position, body.Radius, c.candidates[:0])
+	for _, j := range c.candidates {
+		if j == i || c.absorbed[j] {
+			continue
+		}
+		other := bodies[j]
+		if !body.CollidesWith(other) {
+			continue
+		}
+
+		winner, loser := i, j
+		if other.Mass > body.Mass || (other.Mass == body.Mass && j < i) {
+			winner, loser = j, i
+		}
+		bodies[winner].MergeWith(bodies[loser])
+		bodies[winner].updateRadius()
+		// the winner has moved and grown, so bodies checked later need to be
+		// able to find it where it is now
+		c.hash.add(winner, bodies[winner])
+		c.absorbed[loser] = true
+		absorbed(loser)
+		return winner
+	}
+	return -1
+}
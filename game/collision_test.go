@@ -0,0 +1,172 @@
+package game
+
+import (
+	"math"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func bodiesWithRadius(bodies []*Body) []*Body {
+	for _, b := range bodies {
+		b.updateRadius()
+	}
+	return bodies
+}
+
+func TestSpatialHashCandidates(t *testing.T) {
+	bodies := []*Body{
+		{Position: Point{0, 0}, Radius: 1},
+		{Position: Point{1.5, 0}, Radius: 1},
+		{Position: Point{100, 100}, Radius: 1},
+		{Position: Point{50, 50}, Radius: 60},
+	}
+
+	var h spatialHash
+	h.build(bodies)
+
+	assert.Equal(t, []int{0, 1, 3}, h.candidates(bodies[0].Position, bodies[0].Radius, nil))
+	assert.Equal(t, []int{2, 3}, h.candidates(bodies[2].Position, bodies[2].Radius, nil))
+	assert.Equal(t, []int{0, 1, 2, 3}, h.candidates(bodies[3].Position, bodies[3].Radius, nil))
+}
+
+func TestResolveCollisions(t *testing.T) {
+	bodies := bodiesWithRadius(randomBodies(2000, 3))
+
+	totalMass := 0.0
+	for _, b := range bodies {
+		totalMass += b.Mass
+	}
+
+	var c collisionResolver
+	absorbed := 0
+	c.resolve(bodies, func(i int) {
+		assert.Equal(t, 0.0, bodies[i].Mass)
+		absorbed++
+	})
+	assert.True(t, absorbed > 0)
+
+	var remaining []*Body
+	remainingMass := 0.0
+	for _, b := range bodies {
+		if b.Mass > 0 {
+			remaining = append(remaining, b)
+			remainingMass += b.Mass
+		}
+	}
+	assert.Equal(t, len(bodies)-absorbed, len(remaining))
+	assert.InDelta(t, totalMass, remainingMass, totalMass*1e-12)
+
+	for i, b := range remaining {
+		for _, other := range remaining[i+1:] {
+			assert.False(t, b.CollidesWith(other))
+		}
+	}
+}
+
+func TestResolveCollisionsChain(t *testing.T) {
+	// a and b collide. once a absorbs b, it grows enough to reach c. d is too
+	// far away to be affected
+	a := &Body{Position: Point{0, 0}, Mass: 100000}
+	b := &Body{Position: Point{30, 0}, Mass: 50000}
+	c := &Body{Position: Point{0, 36}, Mass: 1000}
+	d := &Body{Position: Point{500, 0}, Mass: 1000}
+	bodies := bodiesWithRadius([]*Body{a, b, c, d})
+
+	assert.True(t, a.CollidesWith(b))
+	assert.False(t, a.CollidesWith(c))
+	assert.False(t, b.CollidesWith(c))
+
+	var absorbed []int
+	var resolver collisionResolver
+	resolver.resolve(bodies, func(i int) {
+		absorbed = append(absorbed, i)
+	})
+
+	assert.Equal(t, []int{1, 2}, absorbed)
+	assert.Equal(t, 151000.0, a.Mass)
+	assert.Equal(t, 1000.0, d.Mass)
+}
+
+func TestResolveCollisionsMovedWinner(t *testing.T) {
+	// a absorbs b and d absorbs e, which moves them towards each other and
+	// grows them enough to collide, even though nothing else overlaps. a has
+	// to be found where it is after its merge, not where it was
+	mass := 4 * math.Pi / 3 * 1000
+	a := &Body{Position: Point{0, 0}, Mass: mass}
+	b := &Body{Position: Point{12, 12}, Mass: mass}
+	d := &Body{Position: Point{32, 0}, Mass: mass}
+	e := &Body{Position: Point{20, -12}, Mass: mass}
+	bodies := []*Body{a, b, d, e}
+	// lots of tiny bodies keep the cells small
+	for i := 0; i < 100; i++ {
+		bodies = append(bodies, &Body{Position: Point{1000 + float64(i)*10, 0}, Mass: 0.001})
+	}
+	bodies = bodiesWithRadius(bodies)
+
+	assert.False(t, a.CollidesWith(d))
+	assert.False(t, a.CollidesWith(e))
+	assert.False(t, b.CollidesWith(d))
+	assert.False(t, b.CollidesWith(e))
+
+	var absorbed []int
+	var resolver collisionResolver
+	resolver.resolve(bodies, func(i int) {
+		absorbed = append(absorbed, i)
+	})
+
+	assert.Equal(t, []int{1, 3, 2}, absorbed)
+	assert.InDelta(t, 4*mass, a.Mass, 1e-6)
+}
+
+func TestResolveCollisionsOrder(t *testing.T) {
+	// b is heavier, so it absorbs a even though a is visited first
+	a := &Body{Position: Point{0, 0}, Mass: 1000}
+	b := &Body{Position: Point{5, 0}, Mass: 2000}
+	// equal masses go to whichever comes first
+	c := &Body{Position: Point{1000, 0}, Mass: 1000}
+	d := &Body{Position: Point{1005, 0}, Mass: 1000}
+	bodies := bodiesWithRadius([]*Body{a, b, c, d})
+
+	var absorbed []int
+	var resolver collisionResolver
+	resolver.resolve(bodies, func(i int) {
+		absorbed = append(absorbed, i)
+	})
+
+	assert.Equal(t, []int{0, 3}, absorbed)
+	assert.Equal(t, 3000.0, b.Mass)
+	assert.Equal(t, 2000.0, c.Mass)
+}
+
+func TestResolveCollisionsIsDeterministic(t *testing.T) {
+	var results [][]Body
+	for i := 0; i < 2; i++ {
+		bodies := bodiesWithRadius(randomBodies(2000, 4))
+		var resolver collisionResolver
+		resolver.resolve(bodies, func(int) {})
+
+		var result []Body
+		for _, b := range bodies {
+			result = append(result, *b)
+		}
+		results = append(results, result)
+	}
+	assert.Equal(t, results[0], results[1])
+}
+
+func BenchmarkResolveCollisions(b *testing.B) {
+	for _, n := range []int{100, 1000, 5000} {
+		b.Run(strconv.Itoa(n), func(b *testing.B) {
+			original := bodiesWithRadius(randomBodies(n, 1))
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				bodies := copyBodies(original)
+				var resolver collisionResolver
+				b.StartTimer()
+				resolver.resolve(bodies, func(int) {})
+			}
+		})
+	}
+}
@@ -1,7 +1,19 @@
 package game
 
+import (
+	"time"
+)
+
 const PlayerStartMass = 10000
 
+// DefaultStep is the size of the steps taken by Universe.Advance unless it's
+// changed with Universe.SetFixedStep.
+const DefaultStep = time.Second / 30
+
+// If Universe.Advance falls further behind than this many steps, it gives up
+// on catching up.
+const maxStepsPerAdvance = 5
+
 const decayPerStep = 0.0001
 const outOfBoundsDecayPerStep = 0.01
 const minDecayMass = PlayerStartMass
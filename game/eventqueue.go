@@ -0,0 +1,205 @@
+package game
+
+import (
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// EventPriority decides the order events are applied in, and which limits
+// they're subject to. Each priority has its own capacity, so a flood of one
+// kind of event can't crowd out the others.
+type EventPriority int
+
+const (
+	// Bookkeeping like players joining and leaving. These events are applied
+	// first and aren't subject to per-source quotas.
+	SystemPriority EventPriority = iota
+	// Input from players, like thrust.
+	InputPriority
+	// Bodies spawned by the server.
+	SpawnPriority
+
+	numEventPriorities
+)
+
+func (p EventPriority) String() string {
+	switch p {
+	case SystemPriority:
+		return "system"
+	case InputPriority:
+		return "input"
+	case SpawnPriority:
+		return "spawn"
+	}
+	return "unknown"
+}
+
+// The number of events of each priority that can be waiting at once.
+var eventCapacities = [numEventPriorities]int{
+	SystemPriority: 1000,
+	InputPriority:  4096,
+	SpawnPriority:  256,
+}
+
+// The number of events a single source can have waiting at once, not counting
+// system events.
+const DefaultEventQuota = 32
+
+var (
+	ErrEventQueueFull     = errors.New("event queue is full")
+	ErrEventQuotaExceeded = errors.New("event source has too many events queued")
+)
+
+// Event is something to be done to the universe at the start of the next
+// step. Events are applied in priority order, and events of the same priority
+// are applied in the order they were queued.
+type Event struct {
+	// Who queued the event, like a player's connection. Events without a
+	// source aren't subject to quotas or coalescing.
+	Source   string
+	Priority EventPriority
+	// If set, the event replaces any waiting event with the same source and
+	// key, keeping the waiting event's place in the queue. Repeated thrust
+	// from a player only needs the latest one applied, for example.
+	CoalesceKey string
+	Apply       func()
+}
+
+// EventQueueStats describes a universe's event queue.
+type EventQueueStats struct {
+	// The number of events waiting, by priority.
+	Depth [numEventPriorities]int
+	// The number of events that can be waiting, by priority.
+	Capacity [numEventPriorities]int
+	// The number of events replaced by newer ones with the same key.
+	Coalesced uint64
+	// The number of events turned away because the queue was full or their
+	// source was over its quota.
+	Dropped uint64
+}
+
+// Fullest returns how full the fullest priority's queue is, from 0 to 1.
+func (s EventQueueStats) Fullest() float64 {
+	ret := 0.0
+	for i, n := range s.Depth {
+		if s.Capacity[i] > 0 && float64(n)/float64(s.Capacity[i]) > ret {
+			ret = float64(n) / float64(s.Capacity[i])
+		}
+	}
+	return ret
+}
+
+// TotalDepth returns the total number of events waiting.
+func (s EventQueueStats) TotalDepth() int {
+	ret := 0
+	for _, n := range s.Depth {
+		ret += n
+	}
+	return ret
+}
+
+type coalesceKey struct {
+	source string
+	key    string
+}
+
+// eventQueue is a bounded queue of events that's safe to add to from any
+// goroutine. Adding never blocks.
+type eventQueue struct {
+	mutex     sync.Mutex
+	pending   [numEventPriorities][]*Event
+	bySource  map[string]int
+	byKey     map[coalesceKey]*Event
+	quota     int
+	coalesced uint64
+	dropped   uint64
+}
+
+func newEventQueue() *eventQueue {
+	return &eventQueue{
+		bySource: make(map[string]int),
+		byKey:    make(map[coalesceKey]*Event),
+		quota:    DefaultEventQuota,
+	}
+}
+
+func (q *eventQueue) add(e Event) error {
+	if e.Priority < 0 || e.Priority >= numEventPriorities {
+		return errors.Errorf("invalid event priority %v", e.Priority)
+	}
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	key := coalesceKey{e.Source, e.CoalesceKey}
+	if e.Source != "" && e.CoalesceKey != "" {
+		if waiting, ok := q.byKey[key]; ok && waiting.Priority == e.Priority {
+			waiting.Apply = e.Apply
+			q.coalesced++
+			return nil
+		}
+	}
+
+	if len(q.pending[e.Priority]) >= eventCapacities[e.Priority] {
+		q.dropped++
+		return ErrEventQueueFull
+	}
+	limited := e.Source != "" && e.Priority != SystemPriority
+	if limited && q.bySource[e.Source] >= q.quota {
+		q.dropped++
+		return ErrEventQuotaExceeded
+	}
+
+	queued := e
+	q.pending[e.Priority] = append(q.pending[e.Priority], &queued)
+	if limited {
+		q.bySource[e.Source]++
+	}
+	if e.Source != "" && e.CoalesceKey != "" {
+		q.byKey[key] = &queued
+	}
+	return nil
+}
+
+// take removes every waiting event, returning them in the order they should
+// be applied. Events queued while they're being applied wait for the next
+// step, so a steady stream of events can't hold up a step forever.
+func (q *eventQueue) take() []*Event {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	var ret []*Event
+	for i := range q.pending {
+		ret = append(ret, q.pending[i]...)
+		q.pending[i] = nil
+	}
+	for source := range q.bySource {
+		delete(q.bySource, source)
+	}
+	for key := range q.byKey {
+		delete(q.byKey, key)
+	}
+	return ret
+}
+
+func (q *eventQueue) stats() EventQueueStats {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	ret := EventQueueStats{
+		Capacity:  eventCapacities,
+		Coalesced: q.coalesced,
+		Dropped:   q.dropped,
+	}
+	for i, pending := range q.pending {
+		ret.Depth[i] = len(pending)
+	}
+	return ret
+}
+
+func (q *eventQueue) setQuota(n int) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.quota = n
+}
@@ -0,0 +1,120 @@
+package game
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEventQueuePriorities(t *testing.T) {
+	q := newEventQueue()
+
+	var applied []string
+	event := func(priority EventPriority, name string) Event {
+		return Event{
+			Priority: priority,
+			Apply: func() {
+				applied = append(applied, name)
+			},
+		}
+	}
+	require.NoError(t, q.add(event(SpawnPriority, "spawn")))
+	require.NoError(t, q.add(event(InputPriority, "input 1")))
+	require.NoError(t, q.add(event(SystemPriority, "system")))
+	require.NoError(t, q.add(event(InputPriority, "input 2")))
+	assert.Equal(t, 4, q.stats().TotalDepth())
+
+	for _, e := range q.take() {
+		e.Apply()
+	}
+	assert.Equal(t, []string{"system", "input 1", "input 2", "spawn"}, applied)
+	assert.Equal(t, 0, q.stats().TotalDepth())
+
+	assert.Error(t, q.add(event(numEventPriorities, "bad")))
+}
+
+func TestEventQueueCoalescing(t *testing.T) {
+	q := newEventQueue()
+
+	var applied []int
+	thrust := func(source string, n int) Event {
+		return Event{
+			Source:      source,
+			Priority:    InputPriority,
+			CoalesceKey: "thrust",
+			Apply: func() {
+				applied = append(applied, n)
+			},
+		}
+	}
+	require.NoError(t, q.add(thrust("a", 1)))
+	require.NoError(t, q.add(thrust("b", 2)))
+	require.NoError(t, q.add(thrust("a", 3)))
+	require.NoError(t, q.add(thrust("a", 4)))
+
+	stats := q.stats()
+	assert.Equal(t, 2, stats.Depth[InputPriority])
+	assert.EqualValues(t, 2, stats.Coalesced)
+
+	// a's latest thrust takes the place of its first
+	for _, e := range q.take() {
+		e.Apply()
+	}
+	assert.Equal(t, []int{4, 2}, applied)
+
+	// nothing is coalesced with events that have already been taken
+	require.NoError(t, q.add(thrust("a", 5)))
+	assert.Len(t, q.take(), 1)
+}
+
+func TestEventQueueLimits(t *testing.T) {
+	q := newEventQueue()
+	q.setQuota(2)
+
+	input := Event{Source: "a", Priority: InputPriority, Apply: func() {}}
+	require.NoError(t, q.add(input))
+	require.NoError(t, q.add(input))
+	assert.Equal(t, ErrEventQuotaExceeded, q.add(input))
+
+	// other sources and system events aren't affected
+	require.NoError(t, q.add(Event{Source: "b", Priority: InputPriority, Apply: func() {}}))
+	require.NoError(t, q.add(Event{Source: "a", Priority: SystemPriority, Apply: func() {}}))
+
+	// quotas reset once the events are taken
+	q.take()
+	require.NoError(t, q.add(input))
+
+	for i := 0; i < eventCapacities[SpawnPriority]; i++ {
+		require.NoError(t, q.add(Event{Priority: SpawnPriority, Apply: func() {}}))
+	}
+	assert.Equal(t, ErrEventQueueFull, q.add(Event{Priority: SpawnPriority, Apply: func() {}}))
+	// a full spawn queue doesn't hold up input
+	require.NoError(t, q.add(Event{Source: "b", Priority: InputPriority, Apply: func() {}}))
+
+	stats := q.stats()
+	assert.EqualValues(t, 2, stats.Dropped)
+	assert.Equal(t, 1.0, stats.Fullest())
+}
+
+func TestUniverseQueueEvent(t *testing.T) {
+	u := NewUniverse(Rect{X: 0, Y: 0, W: 100, H: 100})
+
+	var id BodyId
+	require.NoError(t, u.AddEvent(func() {
+		id = u.AddBody(&Body{Position: Point{X: 50, Y: 50}, Mass: 1000})
+	}))
+	require.NoError(t, u.QueueEvent(Event{
+		Source:   "player",
+		Priority: InputPriority,
+		Apply: func() {
+			u.SetThrust(id, Vector{X: 1})
+		},
+	}))
+	assert.Equal(t, 2, u.EventQueueStats().TotalDepth())
+
+	u.Step(DefaultStep)
+	assert.Equal(t, 0, u.EventQueueStats().TotalDepth())
+	require.NotNil(t, u.GetBody(id))
+	assert.Equal(t, Vector{X: 1}, u.GetBody(id).ThrustDirection)
+}
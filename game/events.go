@@ -5,34 +5,62 @@ import (
 	"math/rand"
 )
 
-func randomPointInRect(r Rect) Point {
+func randomPointInRect(rng *rand.Rand, r Rect) Point {
 	return Point{
-		X: rand.Float64()*r.W + r.X,
-		Y: rand.Float64()*r.H + r.Y,
+		X: rng.Float64()*r.W + r.X,
+		Y: rng.Float64()*r.H + r.Y,
 	}
 }
 
-func orbitVector(p Point, b *Body) Vector {
-	v := math.Sqrt(gravitationalConstant * b.Mass / distance(p, b.Position))
+// Returns the heaviest body, preferring the lowest id in case of a tie, or nil
+// if there are no bodies.
+func heaviestBody(u *Universe) *Body {
+	var largest *Body
+	_, bodies := u.orderedBodies()
+	for _, b := range bodies {
+		if largest == nil || b.Mass > largest.Mass {
+			largest = b
+		}
+	}
+	return largest
+}
+
+func orbitVector(p Point, b *Body, g float64) Vector {
+	v := math.Sqrt(g * b.Mass / distance(p, b.Position))
 	return Vector{p.Y, -p.X}.WithMagnitude(v).Add(b.Velocity)
 }
 
+const (
+	ThreatSpawn = "threat"
+	FoodSpawn   = "food"
+)
+
+// SpawnEvents maps the names of each kind of spawn to the function that
+// creates its event.
+var SpawnEvents = map[string]func(u *Universe) func(){
+	ThreatSpawn: ThreatSpawnEvent,
+	FoodSpawn:   FoodSpawnEvent,
+}
+
+// ThreatSpawnEvent returns an event that spawns a body that's large enough to
+// be a threat to players. It draws from the universe's random number
+// generator when it runs, so it's deterministic for seeded universes.
 func ThreatSpawnEvent(u *Universe) func() {
 	return func() {
-		var largestBody *Body
-		for _, b := range u.Bodies() {
-			if largestBody == nil || b.Mass > largestBody.Mass {
-				largestBody = b
-			}
-		}
+		u.record(Input{
+			Kind:  SpawnInput,
+			Spawn: ThreatSpawn,
+		})
 
-		p := randomPointInRect(u.Bounds())
+		largestBody := heaviestBody(u)
+
+		p := randomPointInRect(u.rand, u.Bounds())
 		m := float64(PlayerStartMass)
 		v := Vector{0, 0}
 
 		if largestBody != nil {
-			m = rand.Float64() * math.Min(largestBody.Mass*2, PlayerStartMass*10)
-			v = orbitVector(p, largestBody)
+			m = u.rand.Float64() * math.Min(largestBody.Mass*2, u.physics.ThreatMaxMass)
+			v = orbitVector(p, largestBody, u.physics.GravitationalConstant)
 		}
 
 		b := Body{
@@ -40,25 +68,28 @@ func ThreatSpawnEvent(u *Universe) func() {
 			Mass:     m,
 			Velocity: v,
 		}
-		u.AddBody(&b)
+		u.addBody(&b)
 	}
 }
 
+// FoodSpawnEvent returns an event that spawns a small body for players to
+// absorb. Like ThreatSpawnEvent, it draws from the universe's random number
+// generator.
 func FoodSpawnEvent(u *Universe) func() {
 	return func() {
-		var largestBody *Body
-		for _, b := range u.Bodies() {
-			if largestBody == nil || b.Mass > largestBody.Mass {
-				largestBody = b
-			}
-		}
+		u.record(Input{
+			Kind:  SpawnInput,
+			Spawn: FoodSpawn,
+		})
+
+		largestBody := heaviestBody(u)
 
-		p := randomPointInRect(u.Bounds())
-		m := rand.Float64()*PlayerStartMass*0.4 + PlayerStartMass*0.1
+		p := randomPointInRect(u.rand, u.Bounds())
+		m := u.rand.Float64()*(u.physics.FoodMaxMass-u.physics.FoodMinMass) + u.physics.FoodMinMass
 		v := Vector{0, 0}
 
 		if largestBody != nil {
-			v = orbitVector(p, largestBody)
+			v = orbitVector(p, largestBody, u.physics.GravitationalConstant)
 		}
 
 		b := Body{
@@ -66,6 +97,6 @@ func FoodSpawnEvent(u *Universe) func() {
 			Mass:     m,
 			Velocity: v,
 		}
-		u.AddBody(&b)
+		u.addBody(&b)
 	}
 }
@@ -0,0 +1,250 @@
+package game
+
+import (
+	"math"
+)
+
+// GravitySolver computes the gravitational force acting on every body and
+// stores it in Body.GravitationalForce. g is the gravitational constant.
+type GravitySolver interface {
+	ApplyForces(bodies []*Body, g float64)
+}
+
+// ExactGravity sums the force between every pair of bodies. It's O(n²), but
+// it's exact, so it's what the other solvers are measured against.
+type ExactGravity struct{}
+
+func (ExactGravity) ApplyForces(bodies []*Body, g float64) {
+	for _, b := range bodies {
+		b.GravitationalForce = Vector{}
+	}
+	for i, b := range bodies {
+		for _, other := range bodies[i+1:] {
+			f := b.gravitationalForceTo(other, g)
+			b.GravitationalForce = b.GravitationalForce.Add(f)
+			other.GravitationalForce = other.GravitationalForce.Sub(f)
+		}
+	}
+}
+
+// Past this depth, bodies that still share a quadrant are kept together in a
+// single leaf instead of subdividing further. This only happens when bodies
+// are (nearly) on top of each other.
+const barnesHutMaxDepth = 48
+
+// BarnesHutGravity approximates gravity in O(n log n) by grouping distant
+// bodies into the nodes of a quadtree and treating each group as a single
+// mass at its center of mass.
+//
+// Theta is the opening angle. A node is treated as a single mass when its
+// width divided by its distance to the body is less than Theta. Zero gives
+// the same result as ExactGravity, and larger values trade accuracy for
+// speed. 0.5 is a reasonable default.
+//
+// The tree is reused between calls, so a BarnesHutGravity must not be used by
+// more than one universe at a time.
+type BarnesHutGravity struct {
+	Theta float64
+
+	nodes []quadNode
+	next  []int
+	stack []int
+}
+
+func NewBarnesHutGravity(theta float64) *BarnesHutGravity {
+	return &BarnesHutGravity{
+		Theta: theta,
+	}
+}
+
+type quadNode struct {
+	x, y, size float64
+	mass       float64
+	// This is the mass-weighted sum of positions while the tree is being
+	// built, then the center of mass once it's done.
+	center Point
+	// Zero means there's no child. The root is the only node at index zero,
+	// so it can never be anyone's child.
+	children [4]int
+	leaf     bool
+	// For leaves, this is the first body in the leaf, or -1 if it's empty.
+	// The rest can be found by following next.
+	body int
+}
+
+func (n *quadNode) add(b *Body) {
+	n.mass += b.Mass
+	n.center.X += b.Position.X * b.Mass
+	n.center.Y += b.Position.Y * b.Mass
+}
+
+func (n *quadNode) contains(p Point) bool {
+	return p.X >= n.x && p.X <= n.x+n.size &&
+		p.Y >= n.y && p.Y <= n.y+n.size
+}
+
+func (g *BarnesHutGravity) ApplyForces(bodies []*Body, gravitationalConstant float64) {
+	g.build(bodies)
+	for i, b := range bodies {
+		b.GravitationalForce = Vector{}
+		if b.Static {
+			continue
+		}
+		b.GravitationalForce = g.forceOn(bodies, i).Scale(gravitationalConstant)
+	}
+}
+
+func (g *BarnesHutGravity) build(bodies []*Body) {
+	g.nodes = g.nodes[:0]
+	if cap(g.next) < len(bodies) {
+		g.next = make([]int, len(bodies))
+	}
+	g.next = g.next[:len(bodies)]
+
+	// static bodies don't exert any force, so they're left out of the tree
+	min := Point{math.Inf(1), math.Inf(1)}
+	max := Point{math.Inf(-1), math.Inf(-1)}
+	for _, b := range bodies {
+		if b.Static {
+			continue
+		}
+		min.X = math.Min(min.X, b.Position.X)
+		min.Y = math.Min(min.Y, b.Position.Y)
+		max.X = math.Max(max.X, b.Position.X)
+		max.Y = math.Max(max.Y, b.Position.Y)
+	}
+
+	g.nodes = append(g.nodes, quadNode{
+		x:    min.X,
+		y:    min.Y,
+		size: math.Max(max.X-min.X, max.Y-min.Y),
+		leaf: true,
+		body: -1,
+	})
+
+	for i, b := range bodies {
+		g.next[i] = -1
+		if !b.Static {
+			g.insert(bodies, i)
+		}
+	}
+
+	for i := range g.nodes {
+		n := &g.nodes[i]
+		if n.mass > 0 {
+			n.center.X /= n.mass
+			n.center.Y /= n.mass
+		}
+	}
+}
+
+func (g *BarnesHutGravity) insert(bodies []*Body, i int) {
+	b := bodies[i]
+	n := 0
+	for depth := 0; ; depth++ {
+		g.nodes[n].add(b)
+		if !g.nodes[n].leaf {
+			n = g.child(n, b.Position)
+			continue
+		}
+		if g.nodes[n].body < 0 {
+			g.nodes[n].body = i
+			return
+		}
+		if depth >= barnesHutMaxDepth {
+			g.next[i] = g.nodes[n].body
+			g.nodes[n].body = i
+			return
+		}
+
+		// split the leaf, moving its body down a level, then keep looking
+		// for a place to put this one
+		existing := g.nodes[n].body
+		g.nodes[n].leaf = false
+		g.nodes[n].body = -1
+		c := g.child(n, bodies[existing].Position)
+		g.nodes[c].add(bodies[existing])
+		g.nodes[c].body = existing
+		n = g.child(n, b.Position)
+	}
+}
+
+// Returns the index of the child of n that contains p, creating it if needed.
+func (g *BarnesHutGravity) child(n int, p Point) int {
+	parent := g.nodes[n]
+	half := parent.size / 2
+	q := 0
+	x, y := parent.x, parent.y
+	if p.X >= parent.x+half {
+		q |= 1
+		x += half
+	}
+	if p.Y >= parent.y+half {
+		q |= 2
+		y += half
+	}
+	if c := parent.children[q]; c != 0 {
+		return c
+	}
+	c := len(g.nodes)
+	g.nodes = append(g.nodes, quadNode{
+		x:    x,
+		y:    y,
+		size: half,
+		leaf: true,
+		body: -1,
+	})
+	g.nodes[n].children[q] = c
+	return c
+}
+
+func (g *BarnesHutGravity) forceOn(bodies []*Body, i int) Vector {
+	b := bodies[i]
+	theta2 := g.Theta * g.Theta
+
+	var f Vector
+	g.stack = append(g.stack[:0], 0)
+	for len(g.stack) > 0 {
+		n := &g.nodes[g.stack[len(g.stack)-1]]
+		g.stack = g.stack[:len(g.stack)-1]
+
+		if n.mass == 0 {
+			continue
+		}
+
+		if n.leaf {
+			for j := n.body; j >= 0; j = g.next[j] {
+				if j != i {
+					f = f.Add(attraction(b.Position, bodies[j].Position, b.Mass*bodies[j].Mass))
+				}
+			}
+			continue
+		}
+
+		if !n.contains(b.Position) && n.size*n.size < theta2*distanceSquared(b.Position, n.center) {
+			f = f.Add(attraction(b.Position, n.center, b.Mass*n.mass))
+			continue
+		}
+
+		for _, c := range n.children {
+			if c != 0 {
+				g.stack = append(g.stack, c)
+			}
+		}
+	}
+	return f
+}
+
+// Returns the force pulling a mass at p toward a mass at other, where m is the
+// product of the two masses, with a gravitational constant of 1. This is the
+// same as Body.GravitationalForceTo, but without the overhead of going through
+// Vector.WithMagnitude.
+func attraction(p, other Point, m float64) Vector {
+	dx, dy := other.X-p.X, other.Y-p.Y
+	d2 := dx*dx + dy*dy
+	if d2 == 0 {
+		panic("cannot compute attraction between coincident points")
+	}
+	s := m / (d2 * math.Sqrt(d2))
+	return Vector{dx * s, dy * s}
+}
@@ -0,0 +1,173 @@
+package game
+
+import (
+	"math/rand"
+	"sort"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func randomBodies(n int, seed int64) []*Body {
+	r := rand.New(rand.NewSource(seed))
+	bodies := make([]*Body, n)
+	for i := range bodies {
+		bodies[i] = &Body{
+			Position: Point{
+				X: r.Float64()*10000 - 5000,
+				Y: r.Float64()*10000 - 5000,
+			},
+			Mass: r.Float64()*PlayerStartMass*10 + minDecayMassForced,
+		}
+	}
+	return bodies
+}
+
+func copyBodies(bodies []*Body) []*Body {
+	ret := make([]*Body, len(bodies))
+	for i, b := range bodies {
+		copy := *b
+		ret[i] = &copy
+	}
+	return ret
+}
+
+// Returns the error in each body's force relative to the magnitude of the
+// exact force.
+func relativeForceErrors(exact, approx []*Body) []float64 {
+	ret := make([]float64, len(exact))
+	for i := range exact {
+		diff := approx[i].GravitationalForce.Sub(exact[i].GravitationalForce)
+		ret[i] = diff.Magnitude() / exact[i].GravitationalForce.Magnitude()
+	}
+	return ret
+}
+
+func TestExactGravity(t *testing.T) {
+	b1 := &Body{
+		Mass:     10,
+		Position: Point{0, 0},
+	}
+	b2 := &Body{
+		Mass:     100,
+		Position: Point{0, 1},
+	}
+	b3 := &Body{
+		Static:   true,
+		Mass:     100,
+		Position: Point{0, 2},
+	}
+
+	ExactGravity{}.ApplyForces([]*Body{b1, b2, b3}, gravitationalConstant)
+
+	assert.Equal(t, Vector{0, 100000}, b1.GravitationalForce)
+	assert.Equal(t, Vector{0, -100000}, b2.GravitationalForce)
+	assert.Equal(t, Vector{0, 0}, b3.GravitationalForce)
+}
+
+func TestBarnesHutGravity(t *testing.T) {
+	exact := randomBodies(500, 1)
+	ExactGravity{}.ApplyForces(exact, gravitationalConstant)
+
+	t.Run("ZeroTheta", func(t *testing.T) {
+		approx := copyBodies(exact)
+		NewBarnesHutGravity(0).ApplyForces(approx, gravitationalConstant)
+		for _, err := range relativeForceErrors(exact, approx) {
+			assert.True(t, err < 1e-9, "relative error %v", err)
+		}
+	})
+
+	// The error for a handful of bodies can be large when the forces on them
+	// nearly cancel out, so the bounds are on percentiles rather than the max.
+	for _, tc := range []struct {
+		Theta     float64
+		MaxMedian float64
+		MaxP90    float64
+	}{
+		{Theta: 0.3, MaxMedian: 0.005, MaxP90: 0.02},
+		{Theta: 0.5, MaxMedian: 0.02, MaxP90: 0.05},
+		{Theta: 1.0, MaxMedian: 0.1, MaxP90: 0.3},
+	} {
+		t.Run("Theta"+strconv.FormatFloat(tc.Theta, 'f', -1, 64), func(t *testing.T) {
+			approx := copyBodies(exact)
+			NewBarnesHutGravity(tc.Theta).ApplyForces(approx, gravitationalConstant)
+
+			errs := relativeForceErrors(exact, approx)
+			sort.Float64s(errs)
+			median := errs[len(errs)/2]
+			p90 := errs[len(errs)*9/10]
+
+			assert.True(t, median < tc.MaxMedian, "median relative error %v", median)
+			assert.True(t, p90 < tc.MaxP90, "90th percentile relative error %v", p90)
+		})
+	}
+}
+
+func TestBarnesHutGravityStaticBodies(t *testing.T) {
+	bodies := randomBodies(50, 2)
+	bodies[0].Static = true
+	bodies[1].Static = true
+
+	exact := copyBodies(bodies)
+	ExactGravity{}.ApplyForces(exact, gravitationalConstant)
+	NewBarnesHutGravity(0).ApplyForces(bodies, gravitationalConstant)
+
+	assert.Equal(t, Vector{0, 0}, bodies[0].GravitationalForce)
+	assert.Equal(t, Vector{0, 0}, bodies[1].GravitationalForce)
+	for _, err := range relativeForceErrors(exact[2:], bodies[2:]) {
+		assert.True(t, err < 1e-9, "relative error %v", err)
+	}
+}
+
+func TestBarnesHutGravityNearlyCoincidentBodies(t *testing.T) {
+	// these two are too close together to ever be split into separate leaves
+	bodies := []*Body{
+		{Mass: 10, Position: Point{0, 0}},
+		{Mass: 10, Position: Point{5, 5}},
+		{Mass: 10, Position: Point{5, 5.000000000000001}},
+	}
+
+	exact := copyBodies(bodies)
+	ExactGravity{}.ApplyForces(exact, gravitationalConstant)
+	NewBarnesHutGravity(0.5).ApplyForces(bodies, gravitationalConstant)
+
+	for _, err := range relativeForceErrors(exact, bodies) {
+		assert.True(t, err < 1e-9, "relative error %v", err)
+	}
+}
+
+func TestUniverseGravitySolver(t *testing.T) {
+	u := NewUniverse(Rect{X: 0, Y: 0, W: 100, H: 100})
+	u.SetGravitySolver(NewBarnesHutGravity(0.5))
+
+	b1 := &Body{Mass: 10, Position: Point{0, 0}}
+	b2 := &Body{Mass: 100, Position: Point{0, 1}}
+	u.AddBody(b1)
+	u.AddBody(b2)
+	_, bodies := u.orderedBodies()
+	u.applyForces(bodies)
+
+	assert.Equal(t, Vector{0, 100000}, b1.GravitationalForce)
+	assert.Equal(t, Vector{0, -100000}, b2.GravitationalForce)
+}
+
+func benchmarkGravity(b *testing.B, solver GravitySolver) {
+	for _, n := range []int{100, 1000, 5000} {
+		b.Run(strconv.Itoa(n), func(b *testing.B) {
+			bodies := randomBodies(n, 1)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				solver.ApplyForces(bodies, gravitationalConstant)
+			}
+		})
+	}
+}
+
+func BenchmarkExactGravity(b *testing.B) {
+	benchmarkGravity(b, ExactGravity{})
+}
+
+func BenchmarkBarnesHutGravity(b *testing.B) {
+	benchmarkGravity(b, NewBarnesHutGravity(0.5))
+}
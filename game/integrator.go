@@ -0,0 +1,160 @@
+package game
+
+import (
+	"time"
+)
+
+// Integrator moves bodies forward through time based on the forces acting on
+// them.
+type Integrator interface {
+	// Integrate advances bodies by d. Each body's GravitationalForce must be
+	// up to date when it's called. Integrators that need to evaluate forces
+	// more than once per step can call forces to recompute them from the
+	// bodies' current positions.
+	Integrate(bodies []*Body, d time.Duration, forces func())
+}
+
+// ExplicitEuler moves each body using its velocity from the start of the
+// step, then updates the velocity. It's the simplest integrator, but it adds
+// energy to orbits, so they spiral outward.
+type ExplicitEuler struct{}
+
+func (ExplicitEuler) Integrate(bodies []*Body, d time.Duration, forces func()) {
+	dt := d.Seconds()
+	for _, b := range bodies {
+		if b.Static {
+			continue
+		}
+		a := b.acceleration()
+		b.Position.X += b.Velocity.X * dt
+		b.Position.Y += b.Velocity.Y * dt
+		b.Velocity.X += a.X * dt
+		b.Velocity.Y += a.Y * dt
+	}
+}
+
+// SemiImplicitEuler updates each body's velocity, then moves it using the new
+// velocity. It costs the same as ExplicitEuler, but it's symplectic, so the
+// energy of an orbit oscillates instead of growing. This is what Body.Step
+// does.
+type SemiImplicitEuler struct{}
+
+func (SemiImplicitEuler) Integrate(bodies []*Body, d time.Duration, forces func()) {
+	dt := d.Seconds()
+	for _, b := range bodies {
+		if b.Static {
+			continue
+		}
+		a := b.acceleration()
+		b.Velocity.X += a.X * dt
+		b.Velocity.Y += a.Y * dt
+		b.Position.X += b.Velocity.X * dt
+		b.Position.Y += b.Velocity.Y * dt
+	}
+}
+
+// VelocityVerlet (also known as leapfrog) is a second order symplectic
+// integrator. It evaluates forces once more per step than the Euler
+// integrators.
+type VelocityVerlet struct {
+	accelerations []Vector
+}
+
+func (v *VelocityVerlet) Integrate(bodies []*Body, d time.Duration, forces func()) {
+	dt := d.Seconds()
+
+	v.accelerations = v.accelerations[:0]
+	for _, b := range bodies {
+		if b.Static {
+			v.accelerations = append(v.accelerations, Vector{})
+			continue
+		}
+		a := b.acceleration()
+		v.accelerations = append(v.accelerations, a)
+		b.Position.X += b.Velocity.X*dt + a.X*dt*dt/2
+		b.Position.Y += b.Velocity.Y*dt + a.Y*dt*dt/2
+	}
+
+	forces()
+
+	for i, b := range bodies {
+		if b.Static {
+			continue
+		}
+		a := v.accelerations[i].Add(b.acceleration())
+		b.Velocity.X += a.X * dt / 2
+		b.Velocity.Y += a.Y * dt / 2
+	}
+}
+
+// RK4 is the classic fourth order Runge-Kutta method. It's the most accurate
+// of the integrators for a single step, but it evaluates forces three more
+// times per step than the Euler integrators, and since it isn't symplectic,
+// its energy error still grows over very long runs.
+type RK4 struct {
+	start []rk4State
+	sum   []rk4State
+}
+
+type rk4State struct {
+	Position Point
+	Velocity Vector
+}
+
+func (r *RK4) Integrate(bodies []*Body, d time.Duration, forces func()) {
+	dt := d.Seconds()
+
+	r.start = r.start[:0]
+	r.sum = r.sum[:0]
+	for _, b := range bodies {
+		r.start = append(r.start, rk4State{b.Position, b.Velocity})
+		r.sum = append(r.sum, rk4State{})
+	}
+
+	// Each stage evaluates the derivatives at the current state, adds them to
+	// the weighted sum, then moves the bodies to where the next stage should
+	// evaluate them.
+	stages := []struct {
+		Weight float64
+		Next   float64
+	}{
+		{Weight: 1, Next: dt / 2},
+		{Weight: 2, Next: dt / 2},
+		{Weight: 2, Next: dt},
+		{Weight: 1},
+	}
+	for s, stage := range stages {
+		if s > 0 {
+			forces()
+		}
+		for i, b := range bodies {
+			if b.Static {
+				continue
+			}
+			v := b.Velocity
+			a := b.acceleration()
+			sum := &r.sum[i]
+			sum.Position.X += v.X * stage.Weight
+			sum.Position.Y += v.Y * stage.Weight
+			sum.Velocity.X += a.X * stage.Weight
+			sum.Velocity.Y += a.Y * stage.Weight
+
+			start := r.start[i]
+			b.Position.X = start.Position.X + v.X*stage.Next
+			b.Position.Y = start.Position.Y + v.Y*stage.Next
+			b.Velocity.X = start.Velocity.X + a.X*stage.Next
+			b.Velocity.Y = start.Velocity.Y + a.Y*stage.Next
+		}
+	}
+
+	for i, b := range bodies {
+		if b.Static {
+			continue
+		}
+		start, sum := r.start[i], r.sum[i]
+		b.Position.X = start.Position.X + sum.Position.X*dt/6
+		b.Position.Y = start.Position.Y + sum.Position.Y*dt/6
+		b.Velocity.X = start.Velocity.X + sum.Velocity.X*dt/6
+		b.Velocity.Y = start.Velocity.Y + sum.Velocity.Y*dt/6
+	}
+}
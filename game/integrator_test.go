@@ -0,0 +1,149 @@
+package game
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func totalEnergy(bodies []*Body) float64 {
+	e := 0.0
+	for i, b := range bodies {
+		e += b.Mass * b.Velocity.MagnitudeSquared() / 2
+		for _, other := range bodies[i+1:] {
+			e -= gravitationalConstant * b.Mass * other.Mass / distance(b.Position, other.Position)
+		}
+	}
+	return e
+}
+
+func totalAngularMomentum(bodies []*Body) float64 {
+	l := 0.0
+	for _, b := range bodies {
+		l += b.Mass * (b.Position.X*b.Velocity.Y - b.Position.Y*b.Velocity.X)
+	}
+	return l
+}
+
+// Puts a satellite into a circular orbit around a much heavier body, runs it
+// for the given number of orbits at the server's tick rate, and returns the
+// largest relative drift in total energy and angular momentum seen along the
+// way.
+func orbitDrift(integrator Integrator, orbits int) (energy, angularMomentum float64) {
+	const m1, m2, r = 1000000.0, 1000.0, 1000.0
+	const step = time.Second / 30
+
+	// everything's relative to the center of mass so that it stays put
+	v := math.Sqrt(gravitationalConstant * (m1 + m2) / r)
+	bodies := []*Body{
+		{
+			Mass:     m1,
+			Position: Point{-r * m2 / (m1 + m2), 0},
+			Velocity: Vector{0, -v * m2 / (m1 + m2)},
+		},
+		{
+			Mass:     m2,
+			Position: Point{r * m1 / (m1 + m2), 0},
+			Velocity: Vector{0, v * m1 / (m1 + m2)},
+		},
+	}
+
+	forces := func() {
+		ExactGravity{}.ApplyForces(bodies, gravitationalConstant)
+	}
+
+	e0 := totalEnergy(bodies)
+	l0 := totalAngularMomentum(bodies)
+
+	period := 2 * math.Pi * r / v
+	steps := int(float64(orbits) * period / step.Seconds())
+	for i := 0; i < steps; i++ {
+		forces()
+		integrator.Integrate(bodies, step, forces)
+
+		energy = math.Max(energy, math.Abs((totalEnergy(bodies)-e0)/e0))
+		angularMomentum = math.Max(angularMomentum, math.Abs((totalAngularMomentum(bodies)-l0)/l0))
+	}
+	return
+}
+
+func TestIntegratorDrift(t *testing.T) {
+	const orbits = 20
+
+	for _, tc := range []struct {
+		Name                    string
+		Integrator              Integrator
+		MaxEnergyDrift          float64
+		MaxAngularMomentumDrift float64
+		MinEnergyDrift          float64
+		MinAngularMomentumDrift float64
+	}{
+		// explicit euler is here to make sure the harness can tell a bad
+		// integrator from a good one
+		{Name: "ExplicitEuler", Integrator: ExplicitEuler{}, MinEnergyDrift: 0.1, MinAngularMomentumDrift: 0.1},
+		{Name: "SemiImplicitEuler", Integrator: SemiImplicitEuler{}, MaxEnergyDrift: 1e-3, MaxAngularMomentumDrift: 1e-12},
+		{Name: "VelocityVerlet", Integrator: &VelocityVerlet{}, MaxEnergyDrift: 1e-7, MaxAngularMomentumDrift: 1e-12},
+		{Name: "RK4", Integrator: &RK4{}, MaxEnergyDrift: 1e-8, MaxAngularMomentumDrift: 1e-8},
+	} {
+		t.Run(tc.Name, func(t *testing.T) {
+			energy, angularMomentum := orbitDrift(tc.Integrator, orbits)
+			t.Logf("energy drift: %g, angular momentum drift: %g", energy, angularMomentum)
+
+			if tc.MaxEnergyDrift > 0 {
+				assert.True(t, energy < tc.MaxEnergyDrift, "energy drift %g", energy)
+				assert.True(t, angularMomentum < tc.MaxAngularMomentumDrift, "angular momentum drift %g", angularMomentum)
+			} else {
+				assert.True(t, energy > tc.MinEnergyDrift, "energy drift %g", energy)
+				assert.True(t, angularMomentum > tc.MinAngularMomentumDrift, "angular momentum drift %g", angularMomentum)
+			}
+		})
+	}
+}
+
+func TestIntegratorStaticBodies(t *testing.T) {
+	for _, integrator := range []Integrator{ExplicitEuler{}, SemiImplicitEuler{}, &VelocityVerlet{}, &RK4{}} {
+		b := &Body{
+			Static:   true,
+			Position: Point{1, 2},
+			Velocity: Vector{3, 4},
+			Thrust:   Vector{5, 6},
+		}
+		integrator.Integrate([]*Body{b}, time.Second, func() {})
+		assert.Equal(t, Point{1, 2}, b.Position)
+		assert.Equal(t, Vector{3, 4}, b.Velocity)
+	}
+}
+
+func TestIntegratorThrust(t *testing.T) {
+	for _, integrator := range []Integrator{SemiImplicitEuler{}, &VelocityVerlet{}, &RK4{}} {
+		b := &Body{
+			Mass:   10,
+			Thrust: Vector{20, 0},
+		}
+		integrator.Integrate([]*Body{b}, time.Second, func() {})
+		assert.Equal(t, Vector{2, 0}, b.Velocity)
+	}
+}
+
+func TestUniverseIntegrator(t *testing.T) {
+	u := NewUniverse(Rect{X: 0, Y: 0, W: 100, H: 100})
+	u.SetIntegrator(ExplicitEuler{})
+	physics := u.Physics()
+	physics.ThrustMagnitude = minDecayMassForced
+	u.SetPhysics(physics)
+
+	// light enough that it doesn't decay
+	b := &Body{
+		Mass:            minDecayMassForced,
+		Position:        Point{50, 50},
+		Velocity:        Vector{30, 0},
+		ThrustDirection: East,
+	}
+	u.AddBody(b)
+	u.Step(time.Second)
+
+	assert.Equal(t, Point{80, 50}, b.Position)
+	assert.Equal(t, Vector{31, 0}, b.Velocity)
+}
@@ -0,0 +1,78 @@
+package game
+
+import (
+	"math"
+
+	"github.com/pkg/errors"
+)
+
+// Physics holds the constants a universe is simulated with, including the
+// sizes of the bodies spawned into it. Universes start out with
+// DefaultPhysics.
+type Physics struct {
+	GravitationalConstant float64
+	// The magnitude of the force thrust applies.
+	ThrustMagnitude float64
+	// The fraction of their mass bodies lose each step.
+	DecayPerStep float64
+	// The fraction of their mass bodies outside of the universe's bounds lose
+	// each step.
+	OutOfBoundsDecayPerStep float64
+	// Bodies lighter than this don't decay while in bounds.
+	MinDecayMass float64
+	// Out of bounds bodies lighter than MinDecayMass lose this much mass each
+	// step instead.
+	MinForcedDecayMass float64
+	// Threats are spawned with a random mass up to this or twice the mass of
+	// the heaviest body, whichever is less.
+	ThreatMaxMass float64
+	// Food is spawned with a random mass in this range.
+	FoodMinMass float64
+	FoodMaxMass float64
+}
+
+func DefaultPhysics() Physics {
+	return Physics{
+		GravitationalConstant:   gravitationalConstant,
+		ThrustMagnitude:         thrustBaseMagnitude,
+		DecayPerStep:            decayPerStep,
+		OutOfBoundsDecayPerStep: outOfBoundsDecayPerStep,
+		MinDecayMass:            minDecayMass,
+		MinForcedDecayMass:      minDecayMassForced,
+		ThreatMaxMass:           PlayerStartMass * 10,
+		FoodMinMass:             PlayerStartMass * 0.1,
+		FoodMaxMass:             PlayerStartMass * 0.5,
+	}
+}
+
+// Validate returns an error if the physics can't be simulated sensibly.
+func (p Physics) Validate() error {
+	for _, v := range []float64{p.GravitationalConstant, p.ThrustMagnitude, p.DecayPerStep, p.OutOfBoundsDecayPerStep, p.MinDecayMass, p.MinForcedDecayMass, p.ThreatMaxMass, p.FoodMinMass, p.FoodMaxMass} {
+		if math.IsNaN(v) || math.IsInf(v, 0) || v < 0 {
+			return errors.New("physics constants must be finite and non-negative")
+		}
+	}
+	if p.DecayPerStep > 1 || p.OutOfBoundsDecayPerStep > 1 {
+		return errors.New("decay can't be more than 100% per step")
+	}
+	if p.FoodMinMass > p.FoodMaxMass {
+		return errors.New("food's minimum mass can't be more than its maximum")
+	}
+	return nil
+}
+
+// Physics returns the constants the universe is simulated with.
+func (u *Universe) Physics() Physics {
+	return u.physics
+}
+
+// SetPhysics changes the constants the universe is simulated with. Like
+// AddBody, this is included in recordings, so it should be called from an
+// event or between steps.
+func (u *Universe) SetPhysics(p Physics) {
+	u.physics = p
+	u.record(Input{
+		Kind:    PhysicsInput,
+		Physics: &p,
+	})
+}
@@ -0,0 +1,77 @@
+package game
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPhysicsValidate(t *testing.T) {
+	assert.NoError(t, DefaultPhysics().Validate())
+
+	p := DefaultPhysics()
+	p.GravitationalConstant = -1
+	assert.Error(t, p.Validate())
+
+	p = DefaultPhysics()
+	p.ThrustMagnitude = math.NaN()
+	assert.Error(t, p.Validate())
+
+	p = DefaultPhysics()
+	p.DecayPerStep = 2
+	assert.Error(t, p.Validate())
+
+	p = DefaultPhysics()
+	p.FoodMinMass = p.FoodMaxMass + 1
+	assert.Error(t, p.Validate())
+}
+
+func TestSpawnMasses(t *testing.T) {
+	u := NewUniverse(Rect{X: 0, Y: 0, W: 100, H: 100})
+	u.AddBody(&Body{Position: Point{X: 50, Y: 50}, Mass: 1e9})
+	p := DefaultPhysics()
+	p.ThreatMaxMass = 10
+	p.FoodMinMass = 20
+	p.FoodMaxMass = 30
+	u.SetPhysics(p)
+
+	for i := 0; i < 10; i++ {
+		ThreatSpawnEvent(u)()
+		FoodSpawnEvent(u)()
+	}
+	for id, b := range u.Bodies() {
+		switch {
+		case id == 0:
+		case id%2 == 1:
+			assert.True(t, b.Mass <= 10, "threat mass %v", b.Mass)
+		default:
+			assert.True(t, b.Mass >= 20 && b.Mass <= 30, "food mass %v", b.Mass)
+		}
+	}
+}
+
+func TestSetPhysics(t *testing.T) {
+	u := NewUniverse(Rect{X: 0, Y: 0, W: 100, H: 100})
+	assert.Equal(t, DefaultPhysics(), u.Physics())
+
+	p := DefaultPhysics()
+	p.ThrustMagnitude = 10
+	p.DecayPerStep = 0.5
+	p.MinDecayMass = 0
+	u.SetPhysics(p)
+
+	id := u.AddBody(&Body{Position: Point{X: 50, Y: 50}, Mass: 1000})
+	u.decayBodies()
+	assert.Equal(t, 500.0, u.GetBody(id).Mass)
+
+	u.SetThrust(id, North)
+	u.Step(DefaultStep)
+	assert.Equal(t, Vector{0, 10}, u.GetBody(id).Thrust)
+
+	// bodies that are already thrusting pick up changes right away
+	p.ThrustMagnitude = 20
+	u.SetPhysics(p)
+	u.Step(DefaultStep)
+	assert.Equal(t, Vector{0, 20}, u.GetBody(id).Thrust)
+}
@@ -0,0 +1,24 @@
+package game
+
+// randSource is a rand.Source64 whose state is a single number that can be
+// saved and restored, which isn't possible with the standard library's
+// sources. It's SplitMix64.
+type randSource struct {
+	state uint64
+}
+
+func (s *randSource) Seed(seed int64) {
+	s.state = uint64(seed)
+}
+
+func (s *randSource) Uint64() uint64 {
+	s.state += 0x9e3779b97f4a7c15
+	z := s.state
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
+
+func (s *randSource) Int63() int64 {
+	return int64(s.Uint64() >> 1)
+}
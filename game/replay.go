@@ -0,0 +1,304 @@
+package game
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// RecordingVersion is the version of the recording format. Like
+// SnapshotVersion, it should be bumped whenever older code wouldn't be able to
+// read recordings correctly.
+const RecordingVersion = 2
+
+type InputKind string
+
+const (
+	AddBodyInput    InputKind = "add"
+	RemoveBodyInput InputKind = "remove"
+	ThrustInput     InputKind = "thrust"
+	SpawnInput      InputKind = "spawn"
+	BoundsInput     InputKind = "bounds"
+	PhysicsInput    InputKind = "physics"
+	// A body added by AddBodyAtRandomPosition. Its position is picked again
+	// when it's played back so that the random number generator stays in
+	// step.
+	AddRandomBodyInput InputKind = "addrandom"
+)
+
+// Input is a change made to a universe from the outside, which the simulation
+// can't reproduce on its own. Inputs are made by AddBody,
+// AddBodyAtRandomPosition, RemoveBody, SetThrust, SetBounds, SetPhysics, and
+// the spawn events.
+type Input struct {
+	// The tick the input took effect in. Inputs are applied at the start of a
+	// step, so this is the value of Universe.Tick during that step. Inputs
+	// applied between steps take effect in the next one.
+	Tick   uint64
+	Kind   InputKind
+	BodyId BodyId  `json:",omitempty"`
+	Body   *Body   `json:",omitempty"`
+	Thrust *Vector `json:",omitempty"`
+	// One of the keys of SpawnEvents.
+	Spawn   string   `json:",omitempty"`
+	Bounds  *Rect    `json:",omitempty"`
+	Physics *Physics `json:",omitempty"`
+}
+
+// Recording is a universe's initial state along with every input applied to
+// it. It can be played back by a Player to reproduce the exact same sequence
+// of states.
+type Recording struct {
+	Version int
+	// The size of each step. Universes can be stepped by any amount, but it's
+	// only possible to reproduce them if the steps are the same size.
+	Step time.Duration
+	// The physics the universe was simulated with when the recording started.
+	// Older recordings don't have it, in which case it's the defaults.
+	Physics *Physics `json:",omitempty"`
+	Initial *Snapshot
+	Inputs  []Input
+	// The last tick included in the recording.
+	EndTick uint64
+}
+
+// StartRecording starts recording the universe, replacing any recording
+// that's in progress. The universe should only be stepped with Advance or
+// with steps the size of its fixed step while it's being recorded.
+//
+// Recordings have to start at a tick boundary, so this can't be called from
+// an event.
+func (u *Universe) StartRecording() {
+	if u.stepping {
+		panic("StartRecording called during a step")
+	}
+	physics := u.physics
+	u.recording = &Recording{
+		Version: RecordingVersion,
+		Step:    u.step,
+		Physics: &physics,
+		Initial: u.Snapshot(),
+	}
+}
+
+// StopRecording stops recording and returns the recording. It returns nil if
+// the universe wasn't being recorded.
+func (u *Universe) StopRecording() *Recording {
+	ret := u.recording
+	if ret != nil {
+		ret.EndTick = u.tick
+	}
+	u.recording = nil
+	return ret
+}
+
+func (u *Universe) record(in Input) {
+	if u.recording == nil {
+		return
+	}
+	in.Tick = u.tick
+	if !u.stepping {
+		in.Tick++
+	}
+	u.recording.Inputs = append(u.recording.Inputs, in)
+}
+
+// Applies a recorded input.
+func (u *Universe) applyInput(in Input) error {
+	switch in.Kind {
+	case AddBodyInput:
+		if in.Body == nil {
+			return errors.New("add input has no body")
+		}
+		b := *in.Body
+		if id := u.AddBody(&b); id != in.BodyId {
+			return errors.Errorf("body was added with id %v instead of %v", id, in.BodyId)
+		}
+	case AddRandomBodyInput:
+		if in.Body == nil {
+			return errors.New("add input has no body")
+		}
+		b := *in.Body
+		if id := u.AddBodyAtRandomPosition(&b); id != in.BodyId {
+			return errors.Errorf("body was added with id %v instead of %v", id, in.BodyId)
+		}
+	case RemoveBodyInput:
+		u.RemoveBody(in.BodyId)
+	case ThrustInput:
+		if in.Thrust == nil {
+			return errors.New("thrust input has no thrust")
+		}
+		u.SetThrust(in.BodyId, *in.Thrust)
+	case SpawnInput:
+		spawn, ok := SpawnEvents[in.Spawn]
+		if !ok {
+			return errors.Errorf("unknown spawn %q", in.Spawn)
+		}
+		spawn(u)()
+	case BoundsInput:
+		if in.Bounds == nil {
+			return errors.New("bounds input has no bounds")
+		}
+		u.SetBounds(*in.Bounds)
+	case PhysicsInput:
+		if in.Physics == nil {
+			return errors.New("physics input has no physics")
+		}
+		u.SetPhysics(*in.Physics)
+	default:
+		return errors.Errorf("unknown input kind %q", in.Kind)
+	}
+	return nil
+}
+
+// Write writes the recording as JSON.
+func (r *Recording) Write(w io.Writer) error {
+	return errors.Wrap(json.NewEncoder(w).Encode(r), "unable to write recording")
+}
+
+// ReadRecording reads a recording written by Recording.Write.
+func ReadRecording(r io.Reader) (*Recording, error) {
+	var ret Recording
+	if err := json.NewDecoder(r).Decode(&ret); err != nil {
+		return nil, errors.Wrap(err, "unable to read recording")
+	}
+	if ret.Version != RecordingVersion {
+		return nil, errors.Errorf("unsupported recording version %v", ret.Version)
+	}
+	if ret.Initial == nil {
+		return nil, errors.New("recording has no initial snapshot")
+	}
+	if ret.Step <= 0 {
+		return nil, errors.New("recording has no step size")
+	}
+	return &ret, nil
+}
+
+// Snapshots are taken this often during playback so that seeking backwards
+// doesn't have to start from the beginning.
+const playerKeyframeInterval = 300
+
+// Player plays back a recording.
+type Player struct {
+	recording *Recording
+	universe  *Universe
+	// The index of the next input to apply.
+	next      int
+	keyframes []*Snapshot
+	// The physics at each keyframe, which snapshots don't include.
+	keyframePhysics []Physics
+}
+
+// NewPlayer creates a player for the recording that plays it back using the
+// given universe. The universe's state is replaced with the recording's, but
+// its configuration is kept, so it should be configured the same way as the
+// universe that was recorded.
+func NewPlayer(recording *Recording, universe *Universe) (*Player, error) {
+	if err := universe.Restore(recording.Initial); err != nil {
+		return nil, err
+	}
+	universe.physics = DefaultPhysics()
+	if recording.Physics != nil {
+		universe.physics = *recording.Physics
+	}
+	return &Player{
+		recording:       recording,
+		universe:        universe,
+		next:            firstInputAfter(recording.Inputs, recording.Initial.Tick),
+		keyframes:       []*Snapshot{recording.Initial},
+		keyframePhysics: []Physics{universe.physics},
+	}, nil
+}
+
+// Universe returns the universe being played back. It shouldn't be modified.
+func (p *Player) Universe() *Universe {
+	return p.universe
+}
+
+// Tick returns the tick the player is at.
+func (p *Player) Tick() uint64 {
+	return p.universe.Tick()
+}
+
+// StartTick returns the first tick in the recording.
+func (p *Player) StartTick() uint64 {
+	return p.recording.Initial.Tick
+}
+
+// EndTick returns the last tick in the recording.
+func (p *Player) EndTick() uint64 {
+	return p.recording.EndTick
+}
+
+// Done returns true if the player has reached the end of the recording.
+func (p *Player) Done() bool {
+	return p.Tick() >= p.EndTick()
+}
+
+// StepSize returns the size of the recording's steps.
+func (p *Player) StepSize() time.Duration {
+	return p.recording.Step
+}
+
+// Step advances the player by a single tick. It does nothing if the player is
+// already at the end of the recording.
+func (p *Player) Step() error {
+	if p.Done() {
+		return nil
+	}
+
+	// Inputs are normally applied at the start of the step, before anything
+	// else happens, so applying them right before the step is equivalent.
+	tick := p.Tick() + 1
+	for ; p.next < len(p.recording.Inputs) && p.recording.Inputs[p.next].Tick <= tick; p.next++ {
+		in := p.recording.Inputs[p.next]
+		if err := p.universe.applyInput(in); err != nil {
+			return errors.Wrapf(err, "unable to apply input at tick %v", in.Tick)
+		}
+	}
+	p.universe.Step(p.recording.Step)
+
+	if tick%playerKeyframeInterval == 0 && p.keyframes[len(p.keyframes)-1].Tick < tick {
+		p.keyframes = append(p.keyframes, p.universe.Snapshot())
+		p.keyframePhysics = append(p.keyframePhysics, p.universe.physics)
+	}
+	return nil
+}
+
+// Seek moves the player to the given tick, which is clamped to the range of
+// the recording.
+func (p *Player) Seek(tick uint64) error {
+	if tick < p.StartTick() {
+		tick = p.StartTick()
+	} else if tick > p.EndTick() {
+		tick = p.EndTick()
+	}
+
+	if tick < p.Tick() {
+		i := sort.Search(len(p.keyframes), func(i int) bool {
+			return p.keyframes[i].Tick > tick
+		}) - 1
+		if err := p.universe.Restore(p.keyframes[i]); err != nil {
+			return err
+		}
+		p.universe.physics = p.keyframePhysics[i]
+		p.next = firstInputAfter(p.recording.Inputs, p.keyframes[i].Tick)
+	}
+
+	for p.Tick() < tick {
+		if err := p.Step(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns the index of the first input applied after the given tick.
+func firstInputAfter(inputs []Input, tick uint64) int {
+	return sort.Search(len(inputs), func(i int) bool {
+		return inputs[i].Tick > tick
+	})
+}
@@ -0,0 +1,131 @@
+package game
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Records a universe for the given number of steps with a mix of every kind of
+// input. Returns the recording and the state after each tick.
+func recordUniverse(t *testing.T, steps int) (*Recording, map[uint64]map[BodyId]Body) {
+	u := testUniverse(1)
+	stepWithSpawns(u, 100)
+
+	u.StartRecording()
+	states := map[uint64]map[BodyId]Body{
+		u.Tick(): bodyValues(u),
+	}
+
+	var player, other BodyId
+	for i := 0; i < steps; i++ {
+		switch i {
+		case 5:
+			// like a player joining, which uses the random number generator
+			// partway through the recording
+			u.AddEvent(func() {
+				other = u.AddBodyAtRandomPosition(&Body{Mass: PlayerStartMass})
+			})
+		case 10:
+			u.AddEvent(func() {
+				player = u.AddBody(&Body{
+					Position: Point{100, 100},
+					Mass:     PlayerStartMass,
+				})
+			})
+		case 20:
+			u.AddEvent(func() {
+				u.SetThrust(player, North)
+			})
+		case 200:
+			u.AddEvent(func() {
+				u.SetThrust(player, Vector{})
+			})
+		case 300:
+			u.AddEvent(func() {
+				u.SetBounds(Rect{X: -2000, Y: -2000, W: 4000, H: 4000})
+				physics := u.Physics()
+				physics.GravitationalConstant *= 2
+				u.SetPhysics(physics)
+			})
+		case 400:
+			u.AddEvent(func() {
+				u.RemoveBody(player)
+				u.RemoveBody(other)
+			})
+		}
+		stepWithSpawns(u, 1)
+		states[u.Tick()] = bodyValues(u)
+	}
+
+	recording := u.StopRecording()
+	require.NotNil(t, recording)
+	assert.Nil(t, u.StopRecording())
+	return recording, states
+}
+
+func TestRecordingRoundTrip(t *testing.T) {
+	recording, _ := recordUniverse(t, 100)
+
+	var buf bytes.Buffer
+	require.NoError(t, recording.Write(&buf))
+	read, err := ReadRecording(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, recording, read)
+}
+
+func TestReadRecordingVersion(t *testing.T) {
+	_, err := ReadRecording(strings.NewReader(`{"Version":999}`))
+	assert.Error(t, err)
+}
+
+func TestPlayer(t *testing.T) {
+	recording, states := recordUniverse(t, 700)
+
+	kinds := make(map[InputKind]int)
+	for _, in := range recording.Inputs {
+		kinds[in.Kind]++
+	}
+	assert.Equal(t, 1, kinds[AddBodyInput])
+	assert.Equal(t, 1, kinds[AddRandomBodyInput])
+	assert.Equal(t, 2, kinds[RemoveBodyInput])
+	assert.Equal(t, 2, kinds[ThrustInput])
+	assert.NotZero(t, kinds[SpawnInput])
+	assert.Equal(t, 1, kinds[BoundsInput])
+	assert.Equal(t, 1, kinds[PhysicsInput])
+
+	player, err := NewPlayer(recording, testUniverse(2))
+	require.NoError(t, err)
+	assert.Equal(t, states[player.Tick()], bodyValues(player.Universe()))
+
+	for !player.Done() {
+		require.NoError(t, player.Step())
+		require.Equal(t, states[player.Tick()], bodyValues(player.Universe()), "tick %v", player.Tick())
+	}
+	assert.Equal(t, recording.EndTick, player.Tick())
+
+	// stepping past the end does nothing
+	require.NoError(t, player.Step())
+	assert.Equal(t, recording.EndTick, player.Tick())
+
+	for _, tick := range []uint64{
+		player.StartTick() + 350,
+		player.StartTick() + 10,
+		player.StartTick() + 11,
+		player.StartTick() + 650,
+		0,
+		player.EndTick() + 100,
+	} {
+		require.NoError(t, player.Seek(tick))
+		if tick < player.StartTick() {
+			tick = player.StartTick()
+		} else if tick > player.EndTick() {
+			tick = player.EndTick()
+		}
+		assert.Equal(t, tick, player.Tick())
+		assert.Equal(t, states[tick], bodyValues(player.Universe()), "tick %v", tick)
+	}
+}
@@ -0,0 +1,114 @@
+package game
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// SnapshotVersion is the version of the snapshot format. It should be bumped
+// whenever a change is made that older code wouldn't be able to read
+// correctly.
+const SnapshotVersion = 3
+
+// Snapshot is the complete state of a universe at a tick boundary. It's
+// written as JSON.
+//
+// Configuration such as the gravity solver and integrator isn't part of the
+// snapshot. It belongs to whatever universe the snapshot is restored into.
+type Snapshot struct {
+	Version int
+	Bounds  Rect
+	NextId  BodyId
+	Tick    uint64
+	// The state of the universe's random number generator, so that a restored
+	// universe draws the same numbers the original does.
+	RandState uint64
+	Unstepped time.Duration
+	Bodies    []SnapshotBody
+}
+
+type SnapshotBody struct {
+	Id BodyId
+	Body
+}
+
+// Snapshot captures the universe's current state. Queued events can't be
+// saved, so they're applied first. Events are always applied at the start of
+// the next step anyway, so this doesn't change the outcome.
+func (u *Universe) Snapshot() *Snapshot {
+	u.consumeAvailableEvents()
+
+	ids, bodies := u.orderedBodies()
+	ret := &Snapshot{
+		Version:   SnapshotVersion,
+		Bounds:    u.bounds,
+		NextId:    u.nextId,
+		Tick:      u.tick,
+		RandState: u.randSource.state,
+		Unstepped: u.unstepped,
+		Bodies:    make([]SnapshotBody, len(bodies)),
+	}
+	for i, b := range bodies {
+		ret.Bodies[i] = SnapshotBody{
+			Id:   ids[i],
+			Body: *b,
+		}
+	}
+	return ret
+}
+
+// Restore replaces the universe's state with the snapshot's. The universe's
+// configuration and any queued events are kept.
+func (u *Universe) Restore(s *Snapshot) error {
+	if s.Version != SnapshotVersion {
+		return errors.Errorf("unsupported snapshot version %v", s.Version)
+	}
+
+	bodies := make(map[BodyId]*Body, len(s.Bodies))
+	order := make([]BodyId, 0, len(s.Bodies))
+	for _, sb := range s.Bodies {
+		if sb.Id < 0 || sb.Id >= s.NextId {
+			return errors.Errorf("snapshot body id %v is out of range", sb.Id)
+		}
+		if _, ok := bodies[sb.Id]; ok {
+			return errors.Errorf("snapshot contains body id %v more than once", sb.Id)
+		}
+		b := sb.Body
+		bodies[sb.Id] = &b
+		order = append(order, sb.Id)
+	}
+	sort.Slice(order, func(i, j int) bool {
+		return order[i] < order[j]
+	})
+
+	u.bounds = s.Bounds
+	u.bodies = bodies
+	u.order = order
+	u.nextId = s.NextId
+	u.tick = s.Tick
+	u.unstepped = s.Unstepped
+	u.randSource.state = s.RandState
+	u.publishState()
+	return nil
+}
+
+// Write writes the snapshot as JSON.
+func (s *Snapshot) Write(w io.Writer) error {
+	return errors.Wrap(json.NewEncoder(w).Encode(s), "unable to write snapshot")
+}
+
+// ReadSnapshot reads a snapshot written by Snapshot.Write.
+func ReadSnapshot(r io.Reader) (*Snapshot, error) {
+	var s Snapshot
+	if err := json.NewDecoder(r).Decode(&s); err != nil {
+		return nil, errors.Wrap(err, "unable to read snapshot")
+	}
+	if s.Version != SnapshotVersion {
+		return nil, errors.Errorf("unsupported snapshot version %v", s.Version)
+	}
+	return &s, nil
+}
@@ -0,0 +1,108 @@
+package game
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testUniverse(seed int64) *Universe {
+	u := NewUniverse(Rect{X: -5000, Y: -5000, W: 10000, H: 10000})
+	u.SetGravitySolver(NewBarnesHutGravity(0.5))
+	u.Seed(seed)
+	return u
+}
+
+// Steps the universe with a steady supply of spawn events.
+func stepWithSpawns(u *Universe, steps int) {
+	for i := 0; i < steps; i++ {
+		if u.Tick()%3 == 0 {
+			u.AddEvent(FoodSpawnEvent(u))
+		}
+		if u.Tick()%50 == 0 {
+			u.AddEvent(ThreatSpawnEvent(u))
+		}
+		u.Step(DefaultStep)
+	}
+}
+
+func bodyValues(u *Universe) map[BodyId]Body {
+	ret := make(map[BodyId]Body)
+	for id, b := range u.Bodies() {
+		ret[id] = *b
+	}
+	return ret
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	u := testUniverse(1)
+	stepWithSpawns(u, 200)
+	u.AddBody(&Body{
+		MinorName: "Alfa QWERT",
+		MajorName: "Zeus",
+		Mass:      PlayerStartMass,
+		Static:    true,
+		Thrust:    Vector{1, 2},
+	})
+
+	var buf bytes.Buffer
+	require.NoError(t, u.Snapshot().Write(&buf))
+
+	snapshot, err := ReadSnapshot(&buf)
+	require.NoError(t, err)
+
+	restored := NewUniverse(Rect{})
+	require.NoError(t, restored.Restore(snapshot))
+
+	assert.Equal(t, u.Bounds(), restored.Bounds())
+	assert.Equal(t, u.Tick(), restored.Tick())
+	assert.Equal(t, bodyValues(u), bodyValues(restored))
+	assert.Equal(t, u.AddBody(&Body{}), restored.AddBody(&Body{}))
+}
+
+func TestSnapshotDeterminism(t *testing.T) {
+	u := testUniverse(1)
+	stepWithSpawns(u, 200)
+
+	// the snapshot should capture pending events too
+	u.AddEvent(ThreatSpawnEvent(u))
+
+	var buf bytes.Buffer
+	require.NoError(t, u.Snapshot().Write(&buf))
+	snapshot, err := ReadSnapshot(&buf)
+	require.NoError(t, err)
+
+	restored := testUniverse(2)
+	require.NoError(t, restored.Restore(snapshot))
+
+	stepWithSpawns(u, 200)
+	stepWithSpawns(restored, 200)
+	assert.Equal(t, bodyValues(u), bodyValues(restored))
+}
+
+func TestReadSnapshotVersion(t *testing.T) {
+	_, err := ReadSnapshot(strings.NewReader(`{"Version":999}`))
+	assert.Error(t, err)
+}
+
+func TestRestoreInvalidSnapshot(t *testing.T) {
+	u := NewUniverse(Rect{})
+	id := u.AddBody(&Body{Mass: 1})
+
+	assert.Error(t, u.Restore(&Snapshot{
+		Version: SnapshotVersion,
+		NextId:  1,
+		Bodies:  []SnapshotBody{{Id: 1}},
+	}))
+	assert.Error(t, u.Restore(&Snapshot{
+		Version: SnapshotVersion,
+		NextId:  2,
+		Bodies:  []SnapshotBody{{Id: 0}, {Id: 0}},
+	}))
+
+	// a failed restore shouldn't change anything
+	assert.NotNil(t, u.GetBody(id))
+}
@@ -0,0 +1,64 @@
+package game
+
+import (
+	"sort"
+)
+
+// State is a copy of the universe as of the end of a step. States are never
+// modified once they're published, so they can be read from any goroutine
+// while the universe keeps stepping.
+type State struct {
+	Tick   uint64
+	Bounds Rect
+	// in ascending order
+	ids    []BodyId
+	bodies []Body
+}
+
+// State returns the state of the universe as of the last step, or as of when
+// it was created or restored if it hasn't stepped since. It's safe to call
+// from any goroutine.
+func (u *Universe) State() *State {
+	return u.state.Load().(*State)
+}
+
+func (u *Universe) publishState() {
+	ids, bodies := u.orderedBodies()
+	s := &State{
+		Tick:   u.tick,
+		Bounds: u.bounds,
+		ids:    make([]BodyId, len(ids)),
+		bodies: make([]Body, len(bodies)),
+	}
+	copy(s.ids, ids)
+	for i, b := range bodies {
+		s.bodies[i] = *b
+	}
+	u.state.Store(s)
+}
+
+// Len returns the number of bodies.
+func (s *State) Len() int {
+	return len(s.ids)
+}
+
+// Body returns a copy of the body with the given id.
+func (s *State) Body(id BodyId) (Body, bool) {
+	i := sort.Search(len(s.ids), func(i int) bool {
+		return s.ids[i] >= id
+	})
+	if i == len(s.ids) || s.ids[i] != id {
+		return Body{}, false
+	}
+	return s.bodies[i], true
+}
+
+// ForEach calls f for each body in ascending id order until f returns false.
+// The bodies are shared with every other reader, so f mustn't modify them.
+func (s *State) ForEach(f func(id BodyId, b *Body) bool) {
+	for i := range s.ids {
+		if !f(s.ids[i], &s.bodies[i]) {
+			return
+		}
+	}
+}
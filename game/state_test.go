@@ -0,0 +1,98 @@
+package game
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestState(t *testing.T) {
+	u := NewUniverse(Rect{X: 0, Y: 0, W: 100, H: 100})
+	assert.Equal(t, 0, u.State().Len())
+
+	a := u.AddBody(&Body{Position: Point{X: 10, Y: 10}, Mass: 1000})
+	b := u.AddBody(&Body{Position: Point{X: 90, Y: 90}, Mass: 1000})
+
+	// states are only published by steps
+	assert.Equal(t, 0, u.State().Len())
+
+	u.Step(DefaultStep)
+	s := u.State()
+	assert.EqualValues(t, 1, s.Tick)
+	assert.Equal(t, u.Bounds(), s.Bounds)
+	assert.Equal(t, 2, s.Len())
+
+	body, ok := s.Body(a)
+	require.True(t, ok)
+	assert.Equal(t, *u.GetBody(a), body)
+	_, ok = s.Body(b + 1)
+	assert.False(t, ok)
+
+	var ids []BodyId
+	s.ForEach(func(id BodyId, _ *Body) bool {
+		ids = append(ids, id)
+		return true
+	})
+	assert.Equal(t, []BodyId{a, b}, ids)
+
+	ids = nil
+	s.ForEach(func(id BodyId, _ *Body) bool {
+		ids = append(ids, id)
+		return false
+	})
+	assert.Equal(t, []BodyId{a}, ids)
+
+	// old states aren't changed by later steps
+	u.RemoveBody(a)
+	u.Step(DefaultStep)
+	assert.Equal(t, 2, s.Len())
+	assert.Equal(t, 1, u.State().Len())
+
+	// restoring publishes the snapshot's state
+	other := NewUniverse(Rect{X: 0, Y: 0, W: 100, H: 100})
+	require.NoError(t, other.Restore(u.Snapshot()))
+	assert.Equal(t, u.State().Tick, other.State().Tick)
+	assert.Equal(t, 1, other.State().Len())
+}
+
+// This is mostly here for the race detector.
+func TestStateConcurrentReaders(t *testing.T) {
+	u := testUniverse(1)
+	stepWithSpawns(u, 10)
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				s := u.State()
+				mass := 0.0
+				s.ForEach(func(id BodyId, b *Body) bool {
+					mass += b.Mass
+					return true
+				})
+				s.Body(0)
+				u.EventQueueStats()
+				u.QueueEvent(Event{
+					Source:      string(rune('a' + i)),
+					Priority:    InputPriority,
+					CoalesceKey: "noop",
+					Apply:       func() {},
+				})
+			}
+		}(i)
+	}
+
+	stepWithSpawns(u, 100)
+	close(stop)
+	wg.Wait()
+}
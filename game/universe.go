@@ -3,36 +3,164 @@ package game
 import (
 	"math/rand"
 	"sort"
+	"sync/atomic"
 	"time"
 )
 
 type Universe struct {
 	bounds Rect
 	bodies map[BodyId]*Body
-	nextId BodyId
-	events chan func()
+	// The ids of every body in ascending order. This may also contain ids of
+	// bodies that have since been removed.
+	order      []BodyId
+	nextId     BodyId
+	events     *eventQueue
+	state      atomic.Value
+	physics    Physics
+	gravity    GravitySolver
+	integrator Integrator
+	collisions collisionResolver
+	list       []*Body
+	rand       *rand.Rand
+	randSource *randSource
+	recording  *Recording
+	tick       uint64
+	stepping   bool
+	step       time.Duration
+	unstepped  time.Duration
+	onStep     func(StepStats)
+}
+
+// StepStats describes how a step went, for monitoring.
+type StepStats struct {
+	// How long each phase of the step took.
+	Decay      time.Duration
+	Collisions time.Duration
+	Forces     time.Duration
+	Integrate  time.Duration
+	// The number of bodies absorbed by other bodies.
+	Merges int
 }
 
 func NewUniverse(bounds Rect) *Universe {
-	return &Universe{
-		bounds: bounds,
-		bodies: make(map[BodyId]*Body),
-		events: make(chan func(), 1000), // TODO: this isn't too scalable
+	ret := &Universe{
+		bounds:     bounds,
+		bodies:     make(map[BodyId]*Body),
+		events:     newEventQueue(),
+		physics:    DefaultPhysics(),
+		gravity:    ExactGravity{},
+		integrator: SemiImplicitEuler{},
+		step:       DefaultStep,
 	}
+	ret.Seed(time.Now().UnixNano())
+	ret.publishState()
+	return ret
+}
+
+// Seed resets the universe's random number generator. Two universes with the
+// same seed, the same bodies, and the same events queued at the same ticks
+// will evolve identically.
+func (u *Universe) Seed(seed int64) {
+	u.randSource = &randSource{}
+	u.randSource.Seed(seed)
+	u.rand = rand.New(u.randSource)
+}
+
+// Rand returns the universe's random number generator. Like everything else
+// that modifies the universe, it should only be used from events or the
+// goroutine that steps the universe.
+func (u *Universe) Rand() *rand.Rand {
+	return u.rand
+}
+
+// Tick returns the number of steps the universe has taken.
+func (u *Universe) Tick() uint64 {
+	return u.tick
+}
+
+// SetFixedStep changes the size of the steps taken by Advance.
+func (u *Universe) SetFixedStep(d time.Duration) {
+	u.step = d
+}
+
+// SetIntegrator changes how bodies are moved each step. By default, they're
+// moved using SemiImplicitEuler.
+func (u *Universe) SetIntegrator(i Integrator) {
+	u.integrator = i
+}
+
+// SetStepObserver sets a function to be called at the end of every step. It's
+// called from the goroutine that steps the universe.
+func (u *Universe) SetStepObserver(f func(StepStats)) {
+	u.onStep = f
+}
+
+// SetGravitySolver changes how the gravitational force on each body is
+// computed. By default, it's computed exactly.
+func (u *Universe) SetGravitySolver(g GravitySolver) {
+	u.gravity = g
 }
 
 func (u *Universe) Bounds() Rect {
 	return u.bounds
 }
 
+// SetBounds changes the universe's bounds. Bodies outside of them decay
+// faster. Like AddBody, this is included in recordings.
+func (u *Universe) SetBounds(r Rect) {
+	u.bounds = r
+	u.record(Input{
+		Kind:   BoundsInput,
+		Bounds: &r,
+	})
+}
+
+// Bodies returns the universe's bodies. The map is modified every step, so
+// this should only be used from events or the goroutine that steps the
+// universe. Other goroutines should use State instead.
 func (u *Universe) Bodies() map[BodyId]*Body {
 	return u.bodies
 }
 
 func (u *Universe) AddBody(b *Body) BodyId {
+	id := u.addBody(b)
+	if u.recording != nil {
+		copy := *b
+		u.record(Input{
+			Kind:   AddBodyInput,
+			BodyId: id,
+			Body:   &copy,
+		})
+	}
+	return id
+}
+
+// AddBodyAtRandomPosition moves the body to a random point within the
+// universe's bounds and adds it. The point comes from the universe's random
+// number generator, and unlike picking one and calling AddBody, it's picked
+// again when a recording is played back, so the generator stays in step.
+func (u *Universe) AddBodyAtRandomPosition(b *Body) BodyId {
+	b.Position = Point{
+		X: u.bounds.X + u.rand.Float64()*u.bounds.W,
+		Y: u.bounds.Y + u.rand.Float64()*u.bounds.H,
+	}
+	id := u.addBody(b)
+	if u.recording != nil {
+		copy := *b
+		u.record(Input{
+			Kind:   AddRandomBodyInput,
+			BodyId: id,
+			Body:   &copy,
+		})
+	}
+	return id
+}
+
+func (u *Universe) addBody(b *Body) BodyId {
 	id := u.nextId
 	u.nextId++
 	u.bodies[id] = b
+	u.order = append(u.order, id)
 	return id
 }
 
@@ -41,32 +169,103 @@ func (u *Universe) GetBody(id BodyId) *Body {
 }
 
 func (u *Universe) RemoveBody(id BodyId) {
+	u.removeBody(id)
+	u.record(Input{
+		Kind:   RemoveBodyInput,
+		BodyId: id,
+	})
+}
+
+func (u *Universe) removeBody(id BodyId) {
 	delete(u.bodies, id)
 }
 
+// SetThrust sets the direction a body thrusts in. The force of the thrust
+// comes from the universe's physics, so it follows any changes to them.
+func (u *Universe) SetThrust(id BodyId, t Vector) {
+	if b, ok := u.bodies[id]; ok {
+		b.setThrustDirection(t)
+	}
+	u.record(Input{
+		Kind:   ThrustInput,
+		BodyId: id,
+		Thrust: &t,
+	})
+}
+
 func (u *Universe) consumeAvailableEvents() {
-	for {
-		select {
-		case f := <-u.events:
-			f()
-		default:
-			return
+	for _, e := range u.events.take() {
+		e.Apply()
+	}
+}
+
+// ApplyEvents applies any queued events without stepping, then publishes the
+// universe's state. This lets events be applied while the universe is paused.
+// Like Step, it should only be called from the goroutine that steps the
+// universe.
+func (u *Universe) ApplyEvents() {
+	u.consumeAvailableEvents()
+	u.publishState()
+}
+
+// Advance takes as many fixed size steps as fit in the elapsed time, carrying
+// whatever's left over to the next call so that the simulation doesn't depend
+// on how often it's called. If it falls too far behind, the extra time is
+// dropped rather than trying to catch up. Returns the number of steps taken.
+func (u *Universe) Advance(elapsed time.Duration) int {
+	u.unstepped += elapsed
+	steps := 0
+	for u.unstepped >= u.step {
+		if steps == maxStepsPerAdvance {
+			u.unstepped = 0
+			break
 		}
+		u.Step(u.step)
+		u.unstepped -= u.step
+		steps++
 	}
+	return steps
 }
 
 func (u *Universe) Step(d time.Duration) {
+	u.tick++
+	u.stepping = true
+	defer func() {
+		u.stepping = false
+	}()
+
 	u.consumeAvailableEvents()
+
+	var stats StepStats
+	start := time.Now()
 	u.decayBodies()
-	u.checkCollisions()
-	u.applyForces()
+	decayed := time.Now()
+	stats.Merges = u.checkCollisions()
+	collided := time.Now()
 
-	rankings := make([]*Body, 0, len(u.bodies))
-	for _, b := range u.bodies {
-		b.Step(d)
-		rankings = append(rankings, b)
+	_, bodies := u.orderedBodies()
+	u.applyForces(bodies)
+	for _, b := range bodies {
+		b.updateRadius()
+		b.updateThrust(u.physics.ThrustMagnitude)
+		b.updateNetForce(d)
 	}
-	sort.Slice(rankings, func(i, j int) bool {
+	forced := time.Now()
+	u.integrator.Integrate(bodies, d, func() {
+		u.applyForces(bodies)
+	})
+	integrated := time.Now()
+
+	stats.Decay = decayed.Sub(start)
+	stats.Collisions = collided.Sub(decayed)
+	stats.Forces = forced.Sub(collided)
+	stats.Integrate = integrated.Sub(forced)
+
+	// bodies are in id order, so a stable sort keeps ties in a consistent
+	// order
+	rankings := make([]*Body, len(bodies))
+	copy(rankings, bodies)
+	sort.SliceStable(rankings, func(i, j int) bool {
 		return rankings[i].Mass > rankings[j].Mass
 	})
 
@@ -85,65 +284,86 @@ func (u *Universe) Step(d time.Duration) {
 			b.MinorName = u.NewMinorName()
 		}
 	}
+
+	u.publishState()
+	if u.onStep != nil {
+		u.onStep(stats)
+	}
 }
 
 func (u *Universe) decayBodies() {
-	for _, b := range u.bodies {
+	ids, bodies := u.orderedBodies()
+	for i, b := range bodies {
 		if !u.bounds.Contains(b.Position) {
-			b.ForceDecay(outOfBoundsDecayPerStep)
+			b.forceDecay(u.physics.OutOfBoundsDecayPerStep, u.physics.MinDecayMass, u.physics.MinForcedDecayMass)
 		} else {
-			b.Decay(decayPerStep)
+			b.decay(u.physics.DecayPerStep, u.physics.MinDecayMass)
 		}
-	}
-
-	for id, b := range u.bodies {
 		if b.Mass == 0 {
-			u.RemoveBody(id)
+			u.removeBody(ids[i])
 		}
 	}
 }
 
-func (u *Universe) checkCollisions() {
-	for id, body := range u.bodies {
-		for otherId, other := range u.bodies {
-			if id <= otherId {
-				continue
-			}
-			if body.CollidesWith(other) {
-				if body.Mass > other.Mass {
-					body.MergeWith(other)
-					u.RemoveBody(otherId)
-				} else {
-					other.MergeWith(body)
-					u.RemoveBody(id)
-				}
-				// TODO: this could be a lot better
-				u.checkCollisions()
-				return
-			}
-		}
-	}
+// Returns the number of bodies that were absorbed.
+func (u *Universe) checkCollisions() int {
+	ids, bodies := u.orderedBodies()
+	merges := 0
+	u.collisions.resolve(bodies, func(i int) {
+		u.removeBody(ids[i])
+		merges++
+	})
+	return merges
 }
 
-func (u *Universe) applyForces() {
-	for id, body := range u.bodies {
-		netForces := make([]Vector, 0, len(u.bodies))
-		for otherId, other := range u.bodies {
-			if id == otherId {
-				continue
-			}
-			netForces = append(netForces, body.GravitationalForceTo(other))
-		}
-		body.GravitationalForce = Vector{}
-		for _, v := range netForces {
-			body.GravitationalForce.X += v.X
-			body.GravitationalForce.Y += v.Y
+func (u *Universe) applyForces(bodies []*Body) {
+	u.gravity.ApplyForces(bodies, u.physics.GravitationalConstant)
+}
+
+// Returns the ids of all bodies in ascending order along with the bodies
+// themselves. Anything that depends on the order bodies are visited in should
+// use this rather than ranging over the map so that it's deterministic. The
+// slices are reused, so they're only valid until the next call.
+func (u *Universe) orderedBodies() ([]BodyId, []*Body) {
+	ids := u.order[:0]
+	u.list = u.list[:0]
+	for _, id := range u.order {
+		if b, ok := u.bodies[id]; ok {
+			ids = append(ids, id)
+			u.list = append(u.list, b)
 		}
 	}
+	u.order = ids
+	return ids, u.list
+}
+
+// AddEvent queues a system event, to be applied at the start of the next
+// step. It's safe to call from any goroutine.
+func (u *Universe) AddEvent(f func()) error {
+	return u.QueueEvent(Event{
+		Priority: SystemPriority,
+		Apply:    f,
+	})
+}
+
+// QueueEvent queues an event, to be applied at the start of the next step.
+// It's safe to call from any goroutine, and never blocks. If the queue is full
+// or the event's source is over its quota, the event is dropped and an error
+// is returned.
+func (u *Universe) QueueEvent(e Event) error {
+	return u.events.add(e)
+}
+
+// EventQueueStats returns the current state of the universe's event queue.
+// It's safe to call from any goroutine.
+func (u *Universe) EventQueueStats() EventQueueStats {
+	return u.events.stats()
 }
 
-func (u *Universe) AddEvent(f func()) {
-	u.events <- f
+// SetEventQuota changes the number of events a single source can have queued
+// at once.
+func (u *Universe) SetEventQuota(n int) {
+	u.events.setQuota(n)
 }
 
 var phoneticAlphabet = []string{
@@ -156,9 +376,9 @@ var alphaNumeric = "QWERTYUIOPASDFGHJKLZXCVBNM1234567890"
 
 func (u *Universe) NewMinorName() string {
 	for {
-		name := phoneticAlphabet[rand.Intn(len(phoneticAlphabet))] + " "
+		name := phoneticAlphabet[u.rand.Intn(len(phoneticAlphabet))] + " "
 		for i := 0; i < 5; i++ {
-			name += string(rune(alphaNumeric[rand.Intn(len(alphaNumeric))]))
+			name += string(rune(alphaNumeric[u.rand.Intn(len(alphaNumeric))]))
 		}
 		inUse := false
 		for _, body := range u.bodies {
@@ -180,7 +400,7 @@ var majorNames = []string{
 }
 
 func (u *Universe) NewMajorName() string {
-	indices := rand.Perm(len(majorNames))
+	indices := u.rand.Perm(len(majorNames))
 	for _, i := range indices {
 		name := majorNames[i]
 		inUse := false
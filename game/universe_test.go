@@ -2,8 +2,10 @@ package game
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestAddBody(t *testing.T) {
@@ -67,3 +69,79 @@ func TestOutOfBoundsDecay(t *testing.T) {
 
 	assert.True(t, b.Mass < startingMass)
 }
+
+func TestCheckCollisions(t *testing.T) {
+	u := NewUniverse(Rect{X: 0, Y: 0, W: 100, H: 100})
+
+	big := &Body{Position: Point{0, 0}, Mass: 100000, Radius: 30}
+	bigId := u.AddBody(big)
+	smallId := u.AddBody(&Body{Position: Point{10, 0}, Mass: 1000, Radius: 5})
+	farId := u.AddBody(&Body{Position: Point{1000, 0}, Mass: 1000, Radius: 5})
+
+	assert.Equal(t, 1, u.checkCollisions())
+
+	assert.Equal(t, big, u.GetBody(bigId))
+	assert.Nil(t, u.GetBody(smallId))
+	assert.NotNil(t, u.GetBody(farId))
+	assert.Equal(t, 101000.0, big.Mass)
+}
+
+func TestAdvance(t *testing.T) {
+	u := NewUniverse(Rect{X: 0, Y: 0, W: 100, H: 100})
+	u.SetFixedStep(time.Second)
+
+	assert.Equal(t, 0, u.Advance(time.Second/2))
+	assert.Equal(t, uint64(0), u.Tick())
+	assert.Equal(t, 1, u.Advance(time.Second))
+	assert.Equal(t, uint64(1), u.Tick())
+	assert.Equal(t, 2, u.Advance(time.Second*3/2))
+	assert.Equal(t, uint64(3), u.Tick())
+
+	// it shouldn't try to catch up forever
+	assert.Equal(t, maxStepsPerAdvance, u.Advance(time.Hour))
+	assert.Equal(t, 0, u.Advance(time.Second/2))
+}
+
+func TestDeterministicStep(t *testing.T) {
+	run := func() map[BodyId]Body {
+		u := testUniverse(1)
+		stepWithSpawns(u, 300)
+		return bodyValues(u)
+	}
+
+	first := run()
+	assert.NotEmpty(t, first)
+	assert.Equal(t, first, run())
+}
+
+func TestApplyEvents(t *testing.T) {
+	u := NewUniverse(Rect{X: 0, Y: 0, W: 100, H: 100})
+
+	u.AddEvent(func() {
+		u.AddBody(&Body{Position: Point{X: 50, Y: 50}, Mass: 1000})
+		u.SetBounds(Rect{X: 0, Y: 0, W: 200, H: 200})
+	})
+	u.ApplyEvents()
+
+	assert.EqualValues(t, 0, u.Tick())
+	assert.Len(t, u.Bodies(), 1)
+	assert.Equal(t, 1, u.State().Len())
+	assert.Equal(t, Rect{X: 0, Y: 0, W: 200, H: 200}, u.State().Bounds)
+}
+
+func TestStepObserver(t *testing.T) {
+	u := NewUniverse(Rect{X: 0, Y: 0, W: 100, H: 100})
+	u.AddBody(&Body{Position: Point{0, 0}, Mass: 100000, Radius: 30})
+	u.AddBody(&Body{Position: Point{10, 0}, Mass: 1000, Radius: 5})
+
+	var stats []StepStats
+	u.SetStepObserver(func(s StepStats) {
+		stats = append(stats, s)
+	})
+	u.Step(DefaultStep)
+	u.Step(DefaultStep)
+
+	require.Len(t, stats, 2)
+	assert.Equal(t, 1, stats[0].Merges)
+	assert.Equal(t, 0, stats[1].Merges)
+}
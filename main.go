@@ -2,23 +2,99 @@ package main
 
 import (
 	"context"
+	"flag"
+	"io"
+	"io/ioutil"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"time"
 
+	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 
+	"github.com/vmrob/grav-game/game"
 	"github.com/vmrob/grav-game/server"
 )
 
 func main() {
+	snapshotPath := flag.String("snapshot", "", "if set, the universe is restored from this file on startup and saved to it on shutdown")
+	recordPath := flag.String("record", "", "if set, the match is recorded and saved to this file on shutdown")
+	replayPath := flag.String("replay", "", "if set, the recording in this file is played back instead of running a match")
+	configPath := flag.String("config", os.Getenv("GRAV_CONFIG"), "if set, the server is configured with this json file. environment variables and flags override it")
+	configFlags := server.NewConfigFlags(flag.CommandLine)
+	flag.Parse()
+
 	logger := logrus.StandardLogger()
-	s := server.NewServer(logger)
-	defer s.Close()
 
+	loadConfig := func() (server.FileConfig, error) {
+		fileConfig, err := server.LoadFileConfig(*configPath)
+		if err != nil {
+			return fileConfig, err
+		}
+		if err := fileConfig.ApplyEnv(os.LookupEnv); err != nil {
+			return fileConfig, err
+		}
+		return fileConfig, configFlags.Apply(&fileConfig)
+	}
+	fileConfig, err := loadConfig()
+	if err != nil {
+		logger.Fatal(err)
+	}
+	config, err := fileConfig.Config()
+	if err != nil {
+		logger.Fatal(errors.Wrap(err, "invalid configuration"))
+	}
+
+	if *replayPath != "" {
+		s, err := loadReplayServer(logger, *replayPath)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		serve(logger, fileConfig.Listen, s)
+		s.Close()
+		return
+	}
+
+	s, err := loadServer(logger, config, *snapshotPath)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	if *recordPath != "" {
+		s.StartRecording()
+	}
+	if *configPath != "" {
+		s.WatchConfigFile(*configPath, loadConfig, configPollInterval)
+	}
+
+	serve(logger, fileConfig.Listen, s)
+
+	s.Close()
+	if *snapshotPath != "" {
+		if err := saveFile(*snapshotPath, s.Snapshot().Write); err != nil {
+			logger.Error(err)
+		} else {
+			logger.WithField("path", *snapshotPath).Info("saved universe")
+		}
+	}
+	if *recordPath != "" {
+		if err := saveFile(*recordPath, s.StopRecording().Write); err != nil {
+			logger.Error(err)
+		} else {
+			logger.WithField("path", *recordPath).Info("saved recording")
+		}
+	}
+}
+
+// How often the config file is checked for tuning changes.
+const configPollInterval = 2 * time.Second
+
+// Serves the handler at addr until interrupted.
+func serve(logger logrus.FieldLogger, addr string, handler http.Handler) {
 	httpServer := &http.Server{
-		Addr:    ":8080",
-		Handler: s,
+		Addr:    addr,
+		Handler: handler,
 	}
 
 	done := make(chan struct{})
@@ -34,9 +110,65 @@ func main() {
 		close(done)
 	}()
 
-	logger.Info("listening at http://127.0.0.1:8080")
+	logger.WithField("address", addr).Info("listening")
 	if err := httpServer.ListenAndServe(); err != http.ErrServerClosed {
 		logger.Error(err)
 	}
 	<-done
 }
+
+// Creates a server, resuming the universe saved at path if there is one.
+func loadServer(logger logrus.FieldLogger, config server.Config, path string) (*server.Server, error) {
+	if path == "" {
+		return server.NewServerWithConfig(logger, config), nil
+	}
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return server.NewServerWithConfig(logger, config), nil
+	} else if err != nil {
+		return nil, errors.Wrap(err, "unable to open snapshot")
+	}
+	defer f.Close()
+
+	snapshot, err := game.ReadSnapshot(f)
+	if err != nil {
+		return nil, err
+	}
+	logger.WithField("path", path).Info("resuming universe")
+	return server.NewServerFromSnapshotWithConfig(logger, config, snapshot)
+}
+
+func loadReplayServer(logger logrus.FieldLogger, path string) (*server.ReplayServer, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to open recording")
+	}
+	defer f.Close()
+
+	recording, err := game.ReadRecording(f)
+	if err != nil {
+		return nil, err
+	}
+	logger.WithField("path", path).Info("playing back recording")
+	return server.NewReplayServer(logger, recording), nil
+}
+
+// Writes to a temporary file first so that a failure part way through doesn't
+// clobber the previous file.
+func saveFile(path string, write func(w io.Writer) error) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return errors.Wrapf(err, "unable to create %v", path)
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return errors.Wrapf(err, "unable to write %v", path)
+	}
+	return errors.Wrapf(os.Rename(f.Name(), path), "unable to save %v", path)
+}
@@ -0,0 +1,424 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+
+	"github.com/vmrob/grav-game/game"
+)
+
+// How long admin requests wait for their changes to be applied.
+const adminTimeout = 5 * time.Second
+
+var errRoomClosed = errors.New("room is closed")
+
+// AdminBody is a body as it's listed by the admin API.
+type AdminBody struct {
+	Id game.BodyId
+	game.Body
+}
+
+// AdminPlayer is a player as it's listed by the admin API.
+type AdminPlayer struct {
+	BodyId    string
+	Name      string `json:",omitempty"`
+	Color     string `json:",omitempty"`
+	Team      string `json:",omitempty"`
+	Connected bool
+	Latency   time.Duration
+}
+
+// AdminSpawnRequest is the body of a request to spawn a body.
+type AdminSpawnRequest struct {
+	Mass     float64
+	Position game.Point
+	Velocity game.Vector
+}
+
+// AdminRoom is a room as it's listed by the admin API.
+type AdminRoom struct {
+	RoomInfo
+	Tick   uint64
+	Bodies int
+	Paused bool
+}
+
+// Adds the admin API to the router. Every request needs the admin token as a
+// bearer token, and if there isn't one, the API is disabled entirely.
+func (s *Server) addAdminRoutes() {
+	router := s.router.PathPrefix("/admin").Subrouter()
+	router.Use(s.adminAuth)
+	router.HandleFunc("/rooms", s.adminRoomsHandler).Methods("GET")
+	router.HandleFunc("/rooms/{room}", s.adminRoomHandler).Methods("GET")
+	router.HandleFunc("/rooms/{room}/bodies", s.adminBodiesHandler).Methods("GET")
+	router.HandleFunc("/rooms/{room}/bodies", s.adminSpawnHandler).Methods("POST")
+	router.HandleFunc("/rooms/{room}/bodies/{id}", s.adminBodyHandler).Methods("GET")
+	router.HandleFunc("/rooms/{room}/bodies/{id}", s.adminRemoveBodyHandler).Methods("DELETE")
+	router.HandleFunc("/rooms/{room}/players", s.adminPlayersHandler).Methods("GET")
+	router.HandleFunc("/rooms/{room}/pause", s.adminPauseHandler(true)).Methods("POST")
+	router.HandleFunc("/rooms/{room}/resume", s.adminPauseHandler(false)).Methods("POST")
+	router.HandleFunc("/rooms/{room}/step", s.adminStepHandler).Methods("POST")
+	router.HandleFunc("/rooms/{room}/bounds", s.adminBoundsHandler).Methods("PUT")
+	router.HandleFunc("/rooms/{room}/tuning", s.adminTuningHandler).Methods("GET")
+	router.HandleFunc("/rooms/{room}/tuning", s.adminRetuneHandler).Methods("PUT")
+}
+
+func (s *Server) adminAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.config.AdminToken == "" {
+			http.NotFound(w, r)
+			return
+		}
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(s.config.AdminToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		s.logger.WithField("method", r.Method).WithField("path", r.URL.Path).Info("admin request")
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) adminRoomsHandler(w http.ResponseWriter, r *http.Request) {
+	s.roomsMutex.Lock()
+	rooms := make([]*Room, 0, len(s.rooms))
+	for _, room := range s.rooms {
+		rooms = append(rooms, room)
+	}
+	s.roomsMutex.Unlock()
+
+	output := make([]AdminRoom, 0, len(rooms))
+	for _, room := range rooms {
+		output = append(output, room.adminInfo())
+	}
+	sort.Slice(output, func(i, j int) bool {
+		return output[i].Name < output[j].Name
+	})
+	s.writeJSON(w, http.StatusOK, output)
+}
+
+func (s *Server) adminRoomHandler(w http.ResponseWriter, r *http.Request) {
+	if room := s.adminRoom(w, r); room != nil {
+		s.writeJSON(w, http.StatusOK, room.adminInfo())
+	}
+}
+
+func (s *Server) adminBodiesHandler(w http.ResponseWriter, r *http.Request) {
+	room := s.adminRoom(w, r)
+	if room == nil {
+		return
+	}
+	state := room.universe.State()
+	output := make([]AdminBody, 0, state.Len())
+	state.ForEach(func(id game.BodyId, b *game.Body) bool {
+		output = append(output, AdminBody{id, *b})
+		return true
+	})
+	s.writeJSON(w, http.StatusOK, output)
+}
+
+func (s *Server) adminBodyHandler(w http.ResponseWriter, r *http.Request) {
+	room := s.adminRoom(w, r)
+	if room == nil {
+		return
+	}
+	id, ok := adminBodyId(w, r)
+	if !ok {
+		return
+	}
+	body, ok := room.universe.State().Body(id)
+	if !ok {
+		http.Error(w, "no such body", http.StatusNotFound)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, AdminBody{id, body})
+}
+
+func (s *Server) adminSpawnHandler(w http.ResponseWriter, r *http.Request) {
+	room := s.adminRoom(w, r)
+	if room == nil {
+		return
+	}
+	var req AdminSpawnRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	if !(req.Mass > 0) || !finite(req.Mass, req.Position.X, req.Position.Y, req.Velocity.X, req.Velocity.Y) {
+		http.Error(w, "invalid body", http.StatusBadRequest)
+		return
+	}
+
+	var body AdminBody
+	err := room.do(func() {
+		b := &game.Body{
+			Mass:     req.Mass,
+			Position: req.Position,
+			Velocity: req.Velocity,
+		}
+		body = AdminBody{room.universe.AddBody(b), *b}
+	})
+	if err != nil {
+		s.adminError(w, err)
+		return
+	}
+	s.writeJSON(w, http.StatusCreated, body)
+}
+
+func (s *Server) adminRemoveBodyHandler(w http.ResponseWriter, r *http.Request) {
+	room := s.adminRoom(w, r)
+	if room == nil {
+		return
+	}
+	id, ok := adminBodyId(w, r)
+	if !ok {
+		return
+	}
+
+	found := false
+	err := room.do(func() {
+		if room.universe.GetBody(id) != nil {
+			found = true
+			room.universe.RemoveBody(id)
+		}
+	})
+	if err != nil {
+		s.adminError(w, err)
+		return
+	}
+	if !found {
+		http.Error(w, "no such body", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) adminPlayersHandler(w http.ResponseWriter, r *http.Request) {
+	room := s.adminRoom(w, r)
+	if room == nil {
+		return
+	}
+
+	var output []AdminPlayer
+	err := room.do(func() {
+		output = make([]AdminPlayer, 0, len(room.sessions))
+		for _, session := range room.sessions {
+			player := AdminPlayer{
+				BodyId:    session.bodyId.String(),
+				Name:      session.name,
+				Color:     session.color,
+				Team:      session.team,
+				Connected: session.ws != nil,
+			}
+			if session.ws != nil {
+				player.Latency = session.ws.Latency()
+			}
+			output = append(output, player)
+		}
+	})
+	if err != nil {
+		s.adminError(w, err)
+		return
+	}
+	sort.Slice(output, func(i, j int) bool {
+		return output[i].BodyId < output[j].BodyId
+	})
+	s.writeJSON(w, http.StatusOK, output)
+}
+
+func (s *Server) adminPauseHandler(paused bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		room := s.adminRoom(w, r)
+		if room == nil {
+			return
+		}
+		err := room.do(func() {
+			room.setPaused(paused)
+		})
+		if err != nil {
+			s.adminError(w, err)
+			return
+		}
+		s.writeJSON(w, http.StatusOK, room.adminInfo())
+	}
+}
+
+// Steps a paused room by a single tick.
+func (s *Server) adminStepHandler(w http.ResponseWriter, r *http.Request) {
+	room := s.adminRoom(w, r)
+	if room == nil {
+		return
+	}
+	paused := true
+	err := room.do(func() {
+		if paused = room.paused; paused {
+			room.stepsRequested++
+		}
+	})
+	if err != nil {
+		s.adminError(w, err)
+		return
+	}
+	if !paused {
+		http.Error(w, "room isn't paused", http.StatusConflict)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (s *Server) adminBoundsHandler(w http.ResponseWriter, r *http.Request) {
+	room := s.adminRoom(w, r)
+	if room == nil {
+		return
+	}
+	var bounds game.Rect
+	if err := json.NewDecoder(r.Body).Decode(&bounds); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	if !(bounds.W > 0 && bounds.H > 0) || !finite(bounds.X, bounds.Y, bounds.W, bounds.H) {
+		http.Error(w, "invalid bounds", http.StatusBadRequest)
+		return
+	}
+
+	err := room.do(func() {
+		room.universe.SetBounds(bounds)
+		room.webSocketsMutex.Lock()
+		room.roomConfig.Bounds = bounds
+		room.webSocketsMutex.Unlock()
+	})
+	if err != nil {
+		s.adminError(w, err)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, room.adminInfo())
+}
+
+func (s *Server) adminTuningHandler(w http.ResponseWriter, r *http.Request) {
+	if room := s.adminRoom(w, r); room != nil {
+		s.writeJSON(w, http.StatusOK, newFileTuning(room.Tuning()))
+	}
+}
+
+// Changes a room's tuning. Fields that aren't in the request keep their
+// current values. If the room was created from one of the server's room
+// configurations, the change lasts until the config file changes.
+func (s *Server) adminRetuneHandler(w http.ResponseWriter, r *http.Request) {
+	room := s.adminRoom(w, r)
+	if room == nil {
+		return
+	}
+	tuning := newFileTuning(room.Tuning())
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&tuning); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	if err := tuning.tuning().validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := room.Retune(tuning.tuning()); err != nil {
+		s.adminError(w, err)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, newFileTuning(room.Tuning()))
+}
+
+// Returns the room named in the request's path, or writes an error and
+// returns nil if there isn't one.
+func (s *Server) adminRoom(w http.ResponseWriter, r *http.Request) *Room {
+	s.roomsMutex.Lock()
+	defer s.roomsMutex.Unlock()
+	room, ok := s.rooms[mux.Vars(r)["room"]]
+	if !ok {
+		http.Error(w, "no such room", http.StatusNotFound)
+		return nil
+	}
+	return room
+}
+
+func adminBodyId(w http.ResponseWriter, r *http.Request) (game.BodyId, bool) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "invalid body id", http.StatusBadRequest)
+		return 0, false
+	}
+	return game.BodyId(id), true
+}
+
+func (s *Server) adminError(w http.ResponseWriter, err error) {
+	s.logger.WithError(err).Warn("admin request failed")
+	http.Error(w, err.Error(), http.StatusServiceUnavailable)
+}
+
+func (s *Server) writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		s.logger.Warn(err)
+	}
+}
+
+func finite(values ...float64) bool {
+	for _, v := range values {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return false
+		}
+	}
+	return true
+}
+
+// Runs f in an event and waits for it to be applied. Events are still applied
+// while the room is paused, so this doesn't wait for it to be resumed.
+func (r *Room) do(f func()) error {
+	done := make(chan struct{})
+	if err := r.universe.AddEvent(func() {
+		f()
+		close(done)
+	}); err != nil {
+		return err
+	}
+	select {
+	case <-done:
+		return nil
+	case <-r.stopped:
+		return errRoomClosed
+	case <-time.After(adminTimeout):
+		return errors.New("timed out waiting for the room")
+	}
+}
+
+func (r *Room) adminInfo() AdminRoom {
+	state := r.universe.State()
+	r.webSocketsMutex.Lock()
+	paused := r.paused
+	r.webSocketsMutex.Unlock()
+	return AdminRoom{
+		RoomInfo: r.Info(),
+		Tick:     state.Tick,
+		Bodies:   state.Len(),
+		Paused:   paused,
+	}
+}
+
+// This is called from an event.
+func (r *Room) setPaused(paused bool) {
+	r.webSocketsMutex.Lock()
+	r.paused = paused
+	r.webSocketsMutex.Unlock()
+	r.stepsRequested = 0
+	r.logger.WithField("paused", paused).Info("room pause changed")
+}
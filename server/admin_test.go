@@ -0,0 +1,131 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/vmrob/grav-game/game"
+)
+
+func TestAdmin(t *testing.T) {
+	config := DefaultConfig()
+	config.AdminToken = "secret"
+	s := NewServerWithConfig(logrus.StandardLogger(), config)
+	defer s.Close()
+
+	request := func(method, path, token string, body interface{}) *httptest.ResponseRecorder {
+		var buf bytes.Buffer
+		if body != nil {
+			require.NoError(t, json.NewEncoder(&buf).Encode(body))
+		}
+		r := httptest.NewRequest(method, path, &buf)
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		return w
+	}
+	admin := func(method, path string, body interface{}, output interface{}) int {
+		w := request(method, path, "secret", body)
+		if output != nil && w.Code < 300 {
+			require.NoError(t, json.NewDecoder(w.Body).Decode(output))
+		}
+		return w.Code
+	}
+	waitFor := func(what string, f func() bool) {
+		for i := 0; i < 100; i++ {
+			if f() {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("timed out waiting for %v", what)
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, request("GET", "/admin/rooms", "", nil).Code)
+	assert.Equal(t, http.StatusUnauthorized, request("GET", "/admin/rooms", "wrong", nil).Code)
+
+	var rooms []AdminRoom
+	require.Equal(t, http.StatusOK, admin("GET", "/admin/rooms", nil, &rooms))
+	require.Len(t, rooms, 1)
+	assert.Equal(t, DefaultRoomName, rooms[0].Name)
+	assert.Equal(t, http.StatusNotFound, admin("GET", "/admin/rooms/nope", nil, nil))
+
+	// pause so that the universe doesn't change under us
+	var room AdminRoom
+	require.Equal(t, http.StatusOK, admin("POST", "/admin/rooms/default/pause", nil, &room))
+	assert.True(t, room.Paused)
+
+	var spawned AdminBody
+	require.Equal(t, http.StatusCreated, admin("POST", "/admin/rooms/default/bodies", AdminSpawnRequest{
+		Mass:     1000,
+		Position: game.Point{X: 10, Y: 20},
+		Velocity: game.Vector{X: 1},
+	}, &spawned))
+	assert.Equal(t, 1000.0, spawned.Mass)
+	assert.Equal(t, http.StatusBadRequest, admin("POST", "/admin/rooms/default/bodies", AdminSpawnRequest{}, nil))
+
+	// events are applied while paused, but the tick doesn't change
+	var body AdminBody
+	path := "/admin/rooms/default/bodies/" + spawned.Id.String()
+	require.Equal(t, http.StatusOK, admin("GET", path, nil, &body))
+	assert.Equal(t, spawned, body)
+	require.Equal(t, http.StatusOK, admin("GET", "/admin/rooms/default", nil, &room))
+	tick := room.Tick
+
+	var bodies []AdminBody
+	require.Equal(t, http.StatusOK, admin("GET", "/admin/rooms/default/bodies", nil, &bodies))
+	assert.Contains(t, bodies, spawned)
+
+	require.Equal(t, http.StatusAccepted, admin("POST", "/admin/rooms/default/step", nil, nil))
+	waitFor("step", func() bool {
+		admin("GET", "/admin/rooms/default", nil, &room)
+		return room.Tick == tick+1
+	})
+	time.Sleep(100 * time.Millisecond)
+	admin("GET", "/admin/rooms/default", nil, &room)
+	assert.Equal(t, tick+1, room.Tick)
+
+	bounds := game.Rect{X: -100, Y: -100, W: 200, H: 200}
+	require.Equal(t, http.StatusOK, admin("PUT", "/admin/rooms/default/bounds", bounds, &room))
+	assert.Equal(t, bounds, room.Config.Bounds)
+	assert.Equal(t, http.StatusBadRequest, admin("PUT", "/admin/rooms/default/bounds", game.Rect{}, nil))
+
+	assert.Equal(t, http.StatusNoContent, admin("DELETE", path, nil, nil))
+	assert.Equal(t, http.StatusNotFound, admin("DELETE", path, nil, nil))
+
+	client, err := dialWebsocket(s, "/game?name=alice")
+	require.NoError(t, err)
+	defer client.Close()
+	var players []AdminPlayer
+	waitFor("player", func() bool {
+		admin("GET", "/admin/rooms/default/players", nil, &players)
+		return len(players) == 1
+	})
+	assert.Equal(t, "alice", players[0].Name)
+	assert.True(t, players[0].Connected)
+
+	require.Equal(t, http.StatusOK, admin("POST", "/admin/rooms/default/resume", nil, &room))
+	assert.False(t, room.Paused)
+	assert.Equal(t, http.StatusConflict, admin("POST", "/admin/rooms/default/step", nil, nil))
+}
+
+func TestAdminDisabled(t *testing.T) {
+	s := NewServer(logrus.StandardLogger())
+	defer s.Close()
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/admin/rooms", nil)
+	r.Header.Set("Authorization", "Bearer ")
+	s.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
@@ -0,0 +1,251 @@
+package server
+
+import (
+	"encoding/binary"
+	"math"
+
+	"github.com/pkg/errors"
+)
+
+// The wire types used by binaryCodec. They tell the reader how to find the end
+// of a field, so that fields it doesn't know about can be skipped.
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+// binaryWriter writes tagged fields. Methods without the force prefix leave
+// out zero values.
+type binaryWriter struct {
+	buf []byte
+}
+
+func (w *binaryWriter) key(tag, wire int) {
+	w.putUvarint(uint64(tag)<<3 | uint64(wire))
+}
+
+func (w *binaryWriter) putUvarint(v uint64) {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], v)
+	w.buf = append(w.buf, buf[:n]...)
+}
+
+func (w *binaryWriter) uvarint(tag int, v uint64) {
+	if v != 0 {
+		w.forceUvarint(tag, v)
+	}
+}
+
+func (w *binaryWriter) forceUvarint(tag int, v uint64) {
+	w.key(tag, wireVarint)
+	w.putUvarint(v)
+}
+
+func (w *binaryWriter) bool(tag int, v bool) {
+	if v {
+		w.forceBool(tag, v)
+	}
+}
+
+func (w *binaryWriter) forceBool(tag int, v bool) {
+	if v {
+		w.forceUvarint(tag, 1)
+	} else {
+		w.forceUvarint(tag, 0)
+	}
+}
+
+func (w *binaryWriter) float32(tag int, v float32) {
+	if v != 0 {
+		w.key(tag, wireFixed32)
+		var buf [4]byte
+		binary.LittleEndian.PutUint32(buf[:], math.Float32bits(v))
+		w.buf = append(w.buf, buf[:]...)
+	}
+}
+
+func (w *binaryWriter) float64(tag int, v float64) {
+	if v != 0 {
+		w.forceFloat64(tag, v)
+	}
+}
+
+func (w *binaryWriter) forceFloat64(tag int, v float64) {
+	w.key(tag, wireFixed64)
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], math.Float64bits(v))
+	w.buf = append(w.buf, buf[:]...)
+}
+
+func (w *binaryWriter) string(tag int, v string) {
+	if v != "" {
+		w.forceString(tag, v)
+	}
+}
+
+func (w *binaryWriter) forceString(tag int, v string) {
+	w.key(tag, wireBytes)
+	w.putUvarint(uint64(len(v)))
+	w.buf = append(w.buf, v...)
+}
+
+// message writes a nested message containing whatever f writes.
+func (w *binaryWriter) message(tag int, f func(w *binaryWriter)) {
+	start := len(w.buf)
+	f(w)
+	n := len(w.buf) - start
+
+	// the length isn't known until the contents are written, so the header is
+	// inserted in front of them afterwards
+	var header [2 * binary.MaxVarintLen64]byte
+	h := binary.PutUvarint(header[:], uint64(tag)<<3|wireBytes)
+	h += binary.PutUvarint(header[h:], uint64(n))
+	w.buf = append(w.buf, header[:h]...)
+	copy(w.buf[start+h:], w.buf[start:start+n])
+	copy(w.buf[start:], header[:h])
+}
+
+// binaryReader reads tagged fields. The first error encountered is kept and
+// everything after it reads as zero.
+type binaryReader struct {
+	data []byte
+	tag  int
+	wire int
+	err  error
+}
+
+// Decodes a message, calling f for each field.
+func decodeBinary(data []byte, f func(r *binaryReader)) error {
+	r := binaryReader{data: data}
+	for r.next() {
+		f(&r)
+	}
+	return r.err
+}
+
+// next advances to the next field, returning false at the end of the data or
+// if there's an error.
+func (r *binaryReader) next() bool {
+	if r.err != nil || len(r.data) == 0 {
+		return false
+	}
+	key := r.readUvarint()
+	r.tag = int(key >> 3)
+	r.wire = int(key & 7)
+	return r.err == nil
+}
+
+func (r *binaryReader) fail(err error) {
+	if r.err == nil {
+		r.err = err
+	}
+	r.data = nil
+}
+
+func (r *binaryReader) expect(wire int) bool {
+	if r.wire != wire {
+		r.fail(errors.Errorf("field %v has wire type %v instead of %v", r.tag, r.wire, wire))
+	}
+	return r.err == nil
+}
+
+func (r *binaryReader) readUvarint() uint64 {
+	v, n := binary.Uvarint(r.data)
+	if n <= 0 {
+		r.fail(errors.New("invalid varint"))
+		return 0
+	}
+	r.data = r.data[n:]
+	return v
+}
+
+func (r *binaryReader) readFixed(n int) []byte {
+	if len(r.data) < n {
+		r.fail(errors.New("unexpected end of data"))
+		return nil
+	}
+	ret := r.data[:n]
+	r.data = r.data[n:]
+	return ret
+}
+
+func (r *binaryReader) uvarint() uint64 {
+	if !r.expect(wireVarint) {
+		return 0
+	}
+	return r.readUvarint()
+}
+
+func (r *binaryReader) bool() bool {
+	return r.uvarint() != 0
+}
+
+func (r *binaryReader) float32() float32 {
+	if !r.expect(wireFixed32) {
+		return 0
+	}
+	buf := r.readFixed(4)
+	if buf == nil {
+		return 0
+	}
+	return math.Float32frombits(binary.LittleEndian.Uint32(buf))
+}
+
+func (r *binaryReader) float64() float64 {
+	if !r.expect(wireFixed64) {
+		return 0
+	}
+	buf := r.readFixed(8)
+	if buf == nil {
+		return 0
+	}
+	return math.Float64frombits(binary.LittleEndian.Uint64(buf))
+}
+
+func (r *binaryReader) bytes() []byte {
+	if !r.expect(wireBytes) {
+		return nil
+	}
+	n := r.readUvarint()
+	if r.err != nil {
+		return nil
+	}
+	if n > uint64(len(r.data)) {
+		r.fail(errors.New("unexpected end of data"))
+		return nil
+	}
+	return r.readFixed(int(n))
+}
+
+func (r *binaryReader) string() string {
+	return string(r.bytes())
+}
+
+// message reads a nested message, calling f for each of its fields.
+func (r *binaryReader) message(f func(r *binaryReader)) {
+	data := r.bytes()
+	if r.err != nil {
+		return
+	}
+	if err := decodeBinary(data, f); err != nil {
+		r.fail(err)
+	}
+}
+
+// skip skips over the current field.
+func (r *binaryReader) skip() {
+	switch r.wire {
+	case wireVarint:
+		r.readUvarint()
+	case wireFixed64:
+		r.readFixed(8)
+	case wireBytes:
+		r.bytes()
+	case wireFixed32:
+		r.readFixed(4)
+	default:
+		r.fail(errors.Errorf("field %v has unknown wire type %v", r.tag, r.wire))
+	}
+}
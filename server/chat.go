@@ -0,0 +1,183 @@
+package server
+
+import (
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// ChatChannel is who a chat message is for.
+type ChatChannel string
+
+const (
+	// Everyone in the room, including spectators.
+	ChatGlobal ChatChannel = "global"
+	// Players on the sender's team.
+	ChatTeam ChatChannel = "team"
+	// A single player, picked by their body's id.
+	ChatWhisper ChatChannel = "whisper"
+	// Messages from the server to a single player, like when their message
+	// was rejected. Players can't send these.
+	ChatSystem ChatChannel = "system"
+)
+
+// The longest a chat message can be, in characters.
+const maxChatLength = 200
+
+// The number of global and team messages rooms keep around for players that
+// join later.
+const chatHistorySize = 50
+
+// Players can send a burst of this many messages, after which they're limited
+// to chatRate messages per second.
+const (
+	chatBurst = 5
+	chatRate  = 1
+)
+
+// ChatFilter checks chat messages before they're sent. It returns the text to
+// send, which may have been cleaned up, or false if the message shouldn't be
+// sent at all.
+type ChatFilter func(text string) (string, bool)
+
+// rateLimiter is a token bucket.
+type rateLimiter struct {
+	tokens float64
+	last   time.Time
+}
+
+// allow takes a token if one is available.
+func (l *rateLimiter) allow(now time.Time, burst, rate float64) bool {
+	if l.last.IsZero() {
+		l.tokens = burst
+	} else {
+		l.tokens += now.Sub(l.last).Seconds() * rate
+		if l.tokens > burst {
+			l.tokens = burst
+		}
+	}
+	l.last = now
+
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
+
+// Sends a chat message from a player. This is called from an event.
+func (r *Room) chat(ws *WebSocket, input *WebSocketChatInput) {
+	session := ws.session
+	if session.ws != ws {
+		return
+	}
+
+	reject := func(text string) {
+		ws.Send(&WebSocketOutput{
+			Chat: []*WebSocketChatMessage{{Channel: ChatSystem, Text: text}},
+		})
+	}
+
+	text := strings.TrimSpace(input.Text)
+	switch {
+	case text == "":
+		return
+	case utf8.RuneCountInString(text) > maxChatLength:
+		reject("That message is too long.")
+		return
+	case !session.chatLimiter.allow(time.Now(), chatBurst, chatRate):
+		reject("You're sending messages too quickly.")
+		return
+	}
+
+	if r.config.ChatFilter != nil {
+		var ok bool
+		if text, ok = r.config.ChatFilter(text); !ok {
+			reject("That message isn't allowed.")
+			return
+		}
+	}
+
+	msg := &WebSocketChatMessage{
+		Channel:  input.Channel,
+		From:     session.bodyId.String(),
+		FromName: session.name,
+		Text:     text,
+	}
+
+	switch input.Channel {
+	case ChatGlobal:
+	case ChatTeam:
+		if session.team == "" {
+			reject("You aren't on a team.")
+			return
+		}
+		msg.Team = session.team
+	case ChatWhisper:
+		if r.findPlayer(input.To) == nil {
+			reject("That player isn't here.")
+			return
+		}
+		msg.To = input.To
+	default:
+		reject("Unknown chat channel.")
+		return
+	}
+
+	if msg.Channel != ChatWhisper {
+		r.chatHistory = append(r.chatHistory, msg)
+		if len(r.chatHistory) > chatHistorySize {
+			r.chatHistory = r.chatHistory[len(r.chatHistory)-chatHistorySize:]
+		}
+	}
+
+	r.webSocketsMutex.Lock()
+	defer r.webSocketsMutex.Unlock()
+	for other := range r.webSockets {
+		if canSeeChat(other, msg) {
+			other.Send(&WebSocketOutput{
+				Chat: []*WebSocketChatMessage{msg},
+			})
+		}
+	}
+}
+
+// Returns the messages in the room's history the websocket can see. This is
+// called from the run goroutine.
+func (r *Room) visibleChatHistory(ws *WebSocket) []*WebSocketChatMessage {
+	var ret []*WebSocketChatMessage
+	for _, msg := range r.chatHistory {
+		if canSeeChat(ws, msg) {
+			ret = append(ret, msg)
+		}
+	}
+	return ret
+}
+
+// Returns the connected player whose body has the given id.
+func (r *Room) findPlayer(bodyId string) *session {
+	for _, session := range r.sessions {
+		if session.ws != nil && session.bodyId.String() == bodyId && session.hasBody(r.universe) {
+			return session
+		}
+	}
+	return nil
+}
+
+func canSeeChat(ws *WebSocket, msg *WebSocketChatMessage) bool {
+	if msg.Channel == ChatGlobal {
+		return ws.session != nil || ws.spectator != nil
+	}
+	// everything else is just for players
+	if ws.session == nil || ws.session.ws != ws {
+		return false
+	}
+	switch msg.Channel {
+	case ChatTeam:
+		return ws.session.team == msg.Team
+	case ChatWhisper:
+		id := ws.session.bodyId.String()
+		return id == msg.To || id == msg.From
+	}
+	return false
+}
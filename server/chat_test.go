@@ -0,0 +1,154 @@
+package server
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/vmrob/grav-game/game"
+)
+
+func TestRateLimiter(t *testing.T) {
+	var l rateLimiter
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		assert.True(t, l.allow(now, 3, 1))
+	}
+	assert.False(t, l.allow(now, 3, 1))
+	assert.False(t, l.allow(now.Add(time.Second/2), 3, 1))
+	assert.True(t, l.allow(now.Add(time.Second), 3, 1))
+
+	// tokens don't build up past the burst
+	now = now.Add(time.Minute)
+	for i := 0; i < 3; i++ {
+		assert.True(t, l.allow(now, 3, 1))
+	}
+	assert.False(t, l.allow(now, 3, 1))
+}
+
+func TestCanSeeChat(t *testing.T) {
+	player := func(bodyId game.BodyId, team string) *WebSocket {
+		ws := &WebSocket{}
+		ws.session = &session{bodyId: bodyId, team: team, ws: ws}
+		return ws
+	}
+	red1, red2, blue := player(1, "red"), player(2, "red"), player(3, "blue")
+	spectator := &WebSocket{spectator: newSpectator()}
+	joining := &WebSocket{}
+
+	global := &WebSocketChatMessage{Channel: ChatGlobal, From: "1"}
+	team := &WebSocketChatMessage{Channel: ChatTeam, From: "1", Team: "red"}
+	whisper := &WebSocketChatMessage{Channel: ChatWhisper, From: "1", To: "3"}
+
+	for _, tc := range []struct {
+		ws       *WebSocket
+		expected []bool
+	}{
+		{red1, []bool{true, true, true}},
+		{red2, []bool{true, true, false}},
+		{blue, []bool{true, false, true}},
+		{spectator, []bool{true, false, false}},
+		{joining, []bool{false, false, false}},
+	} {
+		for i, msg := range []*WebSocketChatMessage{global, team, whisper} {
+			assert.Equal(t, tc.expected[i], canSeeChat(tc.ws, msg), "%v", msg.Channel)
+		}
+	}
+}
+
+func TestServerChat(t *testing.T) {
+	config := DefaultConfig()
+	config.ChatFilter = func(text string) (string, bool) {
+		if strings.Contains(text, "spam") {
+			return "", false
+		}
+		return strings.Replace(text, "darn", "****", -1), true
+	}
+	s := NewServerWithConfig(logrus.StandardLogger(), config)
+	defer s.Close()
+
+	join := func(query string) (*websocket.Conn, *WebSocketOutput) {
+		client, err := dialWebsocket(s, "/game?"+query)
+		require.NoError(t, err)
+		for {
+			var msg WebSocketOutput
+			require.NoError(t, client.ReadJSON(&msg))
+			if msg.AssignedBodyId != "" {
+				return client, &msg
+			}
+		}
+	}
+	readChat := func(client *websocket.Conn) *WebSocketChatMessage {
+		for i := 0; i < 100; i++ {
+			var msg WebSocketOutput
+			require.NoError(t, client.ReadJSON(&msg))
+			if len(msg.Chat) > 0 {
+				return msg.Chat[0]
+			}
+		}
+		t.Fatal("no chat message received")
+		return nil
+	}
+	say := func(client *websocket.Conn, chat *WebSocketChatInput) {
+		require.NoError(t, client.WriteJSON(&WebSocketInput{Chat: chat}))
+	}
+
+	alice, aliceJoin := join("name=Alice&team=red")
+	defer alice.Close()
+	bob, bobJoin := join("name=Bob&team=blue")
+	defer bob.Close()
+
+	say(alice, &WebSocketChatInput{Channel: ChatGlobal, Text: "well darn"})
+	for _, client := range []*websocket.Conn{alice, bob} {
+		assert.Equal(t, &WebSocketChatMessage{
+			Channel:  ChatGlobal,
+			From:     aliceJoin.AssignedBodyId,
+			FromName: "Alice",
+			Text:     "well ****",
+		}, readChat(client))
+	}
+
+	say(bob, &WebSocketChatInput{Channel: ChatWhisper, To: aliceJoin.AssignedBodyId, Text: "psst"})
+	msg := readChat(alice)
+	assert.Equal(t, ChatWhisper, msg.Channel)
+	assert.Equal(t, bobJoin.AssignedBodyId, msg.From)
+	assert.Equal(t, "psst", msg.Text)
+	assert.Equal(t, "psst", readChat(bob).Text)
+
+	say(bob, &WebSocketChatInput{Channel: ChatGlobal, Text: "spam"})
+	assert.Equal(t, ChatSystem, readChat(bob).Channel)
+	say(bob, &WebSocketChatInput{Channel: ChatGlobal, Text: strings.Repeat("a", maxChatLength+1)})
+	assert.Equal(t, ChatSystem, readChat(bob).Channel)
+	say(bob, &WebSocketChatInput{Channel: ChatWhisper, To: "nobody", Text: "hello?"})
+	assert.Equal(t, ChatSystem, readChat(bob).Channel)
+
+	say(alice, &WebSocketChatInput{Channel: ChatTeam, Text: "go red"})
+	assert.Equal(t, "go red", readChat(alice).Text)
+
+	// late joiners get the history they're allowed to see
+	carol, carolJoin := join("team=red")
+	defer carol.Close()
+	require.Len(t, carolJoin.Chat, 2)
+	assert.Equal(t, "well ****", carolJoin.Chat[0].Text)
+	assert.Equal(t, "go red", carolJoin.Chat[1].Text)
+
+	dave, daveJoin := join("team=blue")
+	defer dave.Close()
+	require.Len(t, daveJoin.Chat, 1)
+	assert.Equal(t, "well ****", daveJoin.Chat[0].Text)
+
+	// chatting too quickly gets players rate limited
+	for i := 0; i < chatBurst; i++ {
+		say(bob, &WebSocketChatInput{Channel: ChatGlobal, Text: "one more"})
+	}
+	limited := false
+	for i := 0; i < chatBurst && !limited; i++ {
+		limited = readChat(bob).Channel == ChatSystem
+	}
+	assert.True(t, limited)
+}
@@ -0,0 +1,542 @@
+package server
+
+import (
+	"encoding/json"
+
+	"github.com/gorilla/websocket"
+	"github.com/pkg/errors"
+
+	"github.com/vmrob/grav-game/game"
+)
+
+// The websocket subprotocols clients can ask for. Clients that don't ask for
+// either get JSON.
+const (
+	BinarySubprotocol = "grav-binary"
+	JSONSubprotocol   = "grav-json"
+)
+
+// codec encodes and decodes the messages sent over a websocket.
+type codec interface {
+	// The websocket message type encoded messages are sent as.
+	MessageType() int
+
+	EncodeOutput(msg *WebSocketOutput) ([]byte, error)
+	DecodeOutput(data []byte, msg *WebSocketOutput) error
+	EncodeInput(msg *WebSocketInput) ([]byte, error)
+	DecodeInput(data []byte, msg *WebSocketInput) error
+}
+
+// Returns the codec for a negotiated subprotocol.
+func codecForSubprotocol(subprotocol string) codec {
+	if subprotocol == BinarySubprotocol {
+		return binaryCodec{}
+	}
+	return jsonCodec{}
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) MessageType() int {
+	return websocket.TextMessage
+}
+
+func (jsonCodec) EncodeOutput(msg *WebSocketOutput) ([]byte, error) {
+	return json.Marshal(msg)
+}
+
+func (jsonCodec) DecodeOutput(data []byte, msg *WebSocketOutput) error {
+	return json.Unmarshal(data, msg)
+}
+
+func (jsonCodec) EncodeInput(msg *WebSocketInput) ([]byte, error) {
+	return json.Marshal(msg)
+}
+
+func (jsonCodec) DecodeInput(data []byte, msg *WebSocketInput) error {
+	if err := json.Unmarshal(data, msg); err != nil {
+		return err
+	}
+	return validateInput(msg)
+}
+
+// Rejects inputs with numbers that aren't finite. A NaN thrust would spread to
+// every body in the universe through gravity, for example.
+func validateInput(msg *WebSocketInput) error {
+	if msg.Thrust != nil && !finite(msg.Thrust.X, msg.Thrust.Y) {
+		return errors.New("thrust must be finite")
+	}
+	if msg.Playback != nil && msg.Playback.Speed != nil && !finite(*msg.Playback.Speed) {
+		return errors.New("playback speed must be finite")
+	}
+	if msg.Ping != nil && !finite(msg.Ping.Time) {
+		return errors.New("ping time must be finite")
+	}
+	if msg.Pong != nil && !finite(msg.Pong.PingTime, msg.Pong.Time) {
+		return errors.New("pong times must be finite")
+	}
+	return nil
+}
+
+// binaryCodec is a compact encoding made up of tagged fields, much like
+// protocol buffers. Fields with unknown tags are skipped, so fields can be
+// added without breaking older clients. Zero values are left out, except for
+// pointers, which are always written when they're set.
+type binaryCodec struct{}
+
+func (binaryCodec) MessageType() int {
+	return websocket.BinaryMessage
+}
+
+func (binaryCodec) EncodeOutput(msg *WebSocketOutput) ([]byte, error) {
+	var w binaryWriter
+	if msg.GameState != nil {
+		w.message(1, func(w *binaryWriter) {
+			encodeGameState(w, msg.GameState)
+		})
+	}
+	w.string(2, msg.AssignedBodyId)
+	if msg.Playback != nil {
+		w.message(3, func(w *binaryWriter) {
+			encodePlaybackState(w, msg.Playback)
+		})
+	}
+	if msg.PlayerLeft != nil {
+		w.message(4, func(w *binaryWriter) {
+			w.string(1, msg.PlayerLeft.BodyId)
+		})
+	}
+	w.string(5, msg.SessionToken)
+	if msg.Match != nil {
+		w.message(6, func(w *binaryWriter) {
+			encodeMatchState(w, msg.Match)
+		})
+	}
+	w.string(7, msg.Room)
+	if msg.Spectating != nil {
+		w.message(8, func(w *binaryWriter) {
+			w.string(1, msg.Spectating.BodyId)
+			w.uvarint(2, uint64(msg.Spectating.Rank))
+		})
+	}
+	for _, chat := range msg.Chat {
+		w.message(9, func(w *binaryWriter) {
+			encodeChatMessage(w, chat)
+		})
+	}
+	encodePingPong(&w, 10, 11, msg.Ping, msg.Pong)
+	return w.buf, nil
+}
+
+func (binaryCodec) DecodeOutput(data []byte, msg *WebSocketOutput) error {
+	err := decodeBinary(data, func(r *binaryReader) {
+		switch r.tag {
+		case 1:
+			msg.GameState = &WebSocketGameState{}
+			msg.GameState.Universe.Bodies = make(map[string]*WebSocketBody)
+			r.message(func(r *binaryReader) {
+				decodeGameState(r, msg.GameState)
+			})
+		case 2:
+			msg.AssignedBodyId = r.string()
+		case 3:
+			msg.Playback = &WebSocketPlaybackState{}
+			r.message(func(r *binaryReader) {
+				decodePlaybackState(r, msg.Playback)
+			})
+		case 4:
+			msg.PlayerLeft = &WebSocketPlayerLeft{}
+			r.message(func(r *binaryReader) {
+				if r.tag == 1 {
+					msg.PlayerLeft.BodyId = r.string()
+				} else {
+					r.skip()
+				}
+			})
+		case 5:
+			msg.SessionToken = r.string()
+		case 6:
+			msg.Match = &WebSocketMatchState{}
+			r.message(func(r *binaryReader) {
+				decodeMatchState(r, msg.Match)
+			})
+		case 7:
+			msg.Room = r.string()
+		case 8:
+			msg.Spectating = &WebSocketSpectatorState{}
+			r.message(func(r *binaryReader) {
+				switch r.tag {
+				case 1:
+					msg.Spectating.BodyId = r.string()
+				case 2:
+					msg.Spectating.Rank = int(r.uvarint())
+				default:
+					r.skip()
+				}
+			})
+		case 9:
+			chat := &WebSocketChatMessage{}
+			r.message(func(r *binaryReader) {
+				decodeChatMessage(r, chat)
+			})
+			msg.Chat = append(msg.Chat, chat)
+		case 10:
+			msg.Ping = decodePing(r)
+		case 11:
+			msg.Pong = decodePong(r)
+		default:
+			r.skip()
+		}
+	})
+	return errors.Wrap(err, "unable to decode output")
+}
+
+func (binaryCodec) EncodeInput(msg *WebSocketInput) ([]byte, error) {
+	var w binaryWriter
+	if msg.Thrust != nil {
+		w.message(1, func(w *binaryWriter) {
+			w.float64(1, msg.Thrust.X)
+			w.float64(2, msg.Thrust.Y)
+		})
+	}
+	w.uvarint(2, msg.Ack)
+	if msg.Playback != nil {
+		w.message(3, func(w *binaryWriter) {
+			encodePlaybackControl(w, msg.Playback)
+		})
+	}
+	if msg.Spectate != nil {
+		w.message(4, func(w *binaryWriter) {
+			w.string(1, msg.Spectate.BodyId)
+			w.string(2, msg.Spectate.Name)
+			w.uvarint(3, uint64(msg.Spectate.Rank))
+		})
+	}
+	if msg.Chat != nil {
+		w.message(5, func(w *binaryWriter) {
+			w.string(1, string(msg.Chat.Channel))
+			w.string(2, msg.Chat.To)
+			w.string(3, msg.Chat.Text)
+		})
+	}
+	w.uvarint(6, msg.Seq)
+	encodePingPong(&w, 7, 8, msg.Ping, msg.Pong)
+	return w.buf, nil
+}
+
+func (binaryCodec) DecodeInput(data []byte, msg *WebSocketInput) error {
+	err := decodeBinary(data, func(r *binaryReader) {
+		switch r.tag {
+		case 1:
+			msg.Thrust = &game.Vector{}
+			r.message(func(r *binaryReader) {
+				switch r.tag {
+				case 1:
+					msg.Thrust.X = r.float64()
+				case 2:
+					msg.Thrust.Y = r.float64()
+				default:
+					r.skip()
+				}
+			})
+		case 2:
+			msg.Ack = r.uvarint()
+		case 3:
+			msg.Playback = &WebSocketPlaybackControl{}
+			r.message(func(r *binaryReader) {
+				decodePlaybackControl(r, msg.Playback)
+			})
+		case 4:
+			msg.Spectate = &WebSocketSpectateControl{}
+			r.message(func(r *binaryReader) {
+				switch r.tag {
+				case 1:
+					msg.Spectate.BodyId = r.string()
+				case 2:
+					msg.Spectate.Name = r.string()
+				case 3:
+					msg.Spectate.Rank = int(r.uvarint())
+				default:
+					r.skip()
+				}
+			})
+		case 5:
+			msg.Chat = &WebSocketChatInput{}
+			r.message(func(r *binaryReader) {
+				switch r.tag {
+				case 1:
+					msg.Chat.Channel = ChatChannel(r.string())
+				case 2:
+					msg.Chat.To = r.string()
+				case 3:
+					msg.Chat.Text = r.string()
+				default:
+					r.skip()
+				}
+			})
+		case 6:
+			msg.Seq = r.uvarint()
+		case 7:
+			msg.Ping = decodePing(r)
+		case 8:
+			msg.Pong = decodePong(r)
+		default:
+			r.skip()
+		}
+	})
+	if err != nil {
+		return errors.Wrap(err, "unable to decode input")
+	}
+	return validateInput(msg)
+}
+
+func encodeGameState(w *binaryWriter, state *WebSocketGameState) {
+	w.uvarint(1, state.Tick)
+	w.uvarint(2, state.BaseTick)
+	w.uvarint(6, state.InputSeq)
+	w.message(3, func(w *binaryWriter) {
+		bounds := state.Universe.Bounds
+		w.float64(1, bounds.X)
+		w.float64(2, bounds.Y)
+		w.float64(3, bounds.W)
+		w.float64(4, bounds.H)
+	})
+	for id, body := range state.Universe.Bodies {
+		w.message(4, func(w *binaryWriter) {
+			w.forceString(1, id)
+			encodeBody(w, body)
+		})
+	}
+	for _, id := range state.Universe.Removed {
+		w.forceString(5, id)
+	}
+}
+
+func decodeGameState(r *binaryReader, state *WebSocketGameState) {
+	switch r.tag {
+	case 1:
+		state.Tick = r.uvarint()
+	case 2:
+		state.BaseTick = r.uvarint()
+	case 3:
+		bounds := &state.Universe.Bounds
+		r.message(func(r *binaryReader) {
+			switch r.tag {
+			case 1:
+				bounds.X = r.float64()
+			case 2:
+				bounds.Y = r.float64()
+			case 3:
+				bounds.W = r.float64()
+			case 4:
+				bounds.H = r.float64()
+			default:
+				r.skip()
+			}
+		})
+	case 4:
+		var id string
+		body := &WebSocketBody{}
+		r.message(func(r *binaryReader) {
+			if r.tag == 1 {
+				id = r.string()
+			} else {
+				decodeBody(r, body)
+			}
+		})
+		state.Universe.Bodies[id] = body
+	case 5:
+		state.Universe.Removed = append(state.Universe.Removed, r.string())
+	case 6:
+		state.InputSeq = r.uvarint()
+	default:
+		r.skip()
+	}
+}
+
+// Bodies are written inline with their ids, so their tags start at 2.
+func encodeBody(w *binaryWriter, body *WebSocketBody) {
+	w.string(2, body.MinorName)
+	w.string(3, body.MajorName)
+	w.float32(4, body.Position.X)
+	w.float32(5, body.Position.Y)
+	w.float32(6, body.Mass)
+	w.float32(7, body.Radius)
+	w.float32(8, body.NetForce.X)
+	w.float32(9, body.NetForce.Y)
+	w.string(10, body.PlayerName)
+	w.string(11, body.Color)
+}
+
+func decodeBody(r *binaryReader, body *WebSocketBody) {
+	switch r.tag {
+	case 2:
+		body.MinorName = r.string()
+	case 3:
+		body.MajorName = r.string()
+	case 4:
+		body.Position.X = r.float32()
+	case 5:
+		body.Position.Y = r.float32()
+	case 6:
+		body.Mass = r.float32()
+	case 7:
+		body.Radius = r.float32()
+	case 8:
+		body.NetForce.X = r.float32()
+	case 9:
+		body.NetForce.Y = r.float32()
+	case 10:
+		body.PlayerName = r.string()
+	case 11:
+		body.Color = r.string()
+	default:
+		r.skip()
+	}
+}
+
+func encodePlaybackState(w *binaryWriter, state *WebSocketPlaybackState) {
+	w.uvarint(1, state.Tick)
+	w.uvarint(2, state.StartTick)
+	w.uvarint(3, state.EndTick)
+	w.bool(4, state.Paused)
+	w.float64(5, state.Speed)
+}
+
+func decodePlaybackState(r *binaryReader, state *WebSocketPlaybackState) {
+	switch r.tag {
+	case 1:
+		state.Tick = r.uvarint()
+	case 2:
+		state.StartTick = r.uvarint()
+	case 3:
+		state.EndTick = r.uvarint()
+	case 4:
+		state.Paused = r.bool()
+	case 5:
+		state.Speed = r.float64()
+	default:
+		r.skip()
+	}
+}
+
+func encodeMatchState(w *binaryWriter, state *WebSocketMatchState) {
+	w.string(1, string(state.Status))
+	w.uvarint(2, uint64(state.Players))
+	w.uvarint(3, uint64(state.MinPlayers))
+	w.uvarint(4, uint64(state.Countdown))
+}
+
+func decodeMatchState(r *binaryReader, state *WebSocketMatchState) {
+	switch r.tag {
+	case 1:
+		state.Status = MatchStatus(r.string())
+	case 2:
+		state.Players = int(r.uvarint())
+	case 3:
+		state.MinPlayers = int(r.uvarint())
+	case 4:
+		state.Countdown = int(r.uvarint())
+	default:
+		r.skip()
+	}
+}
+
+func encodeChatMessage(w *binaryWriter, msg *WebSocketChatMessage) {
+	w.string(1, string(msg.Channel))
+	w.string(2, msg.From)
+	w.string(3, msg.FromName)
+	w.string(4, msg.To)
+	w.string(5, msg.Team)
+	w.string(6, msg.Text)
+}
+
+func decodeChatMessage(r *binaryReader, msg *WebSocketChatMessage) {
+	switch r.tag {
+	case 1:
+		msg.Channel = ChatChannel(r.string())
+	case 2:
+		msg.From = r.string()
+	case 3:
+		msg.FromName = r.string()
+	case 4:
+		msg.To = r.string()
+	case 5:
+		msg.Team = r.string()
+	case 6:
+		msg.Text = r.string()
+	default:
+		r.skip()
+	}
+}
+
+// Pings and pongs are encoded the same way in both directions, just with
+// different tags.
+func encodePingPong(w *binaryWriter, pingTag, pongTag int, ping *WebSocketPing, pong *WebSocketPong) {
+	if ping != nil {
+		w.message(pingTag, func(w *binaryWriter) {
+			w.float64(1, ping.Time)
+		})
+	}
+	if pong != nil {
+		w.message(pongTag, func(w *binaryWriter) {
+			w.float64(1, pong.PingTime)
+			w.float64(2, pong.Time)
+		})
+	}
+}
+
+func decodePing(r *binaryReader) *WebSocketPing {
+	ret := &WebSocketPing{}
+	r.message(func(r *binaryReader) {
+		if r.tag == 1 {
+			ret.Time = r.float64()
+		} else {
+			r.skip()
+		}
+	})
+	return ret
+}
+
+func decodePong(r *binaryReader) *WebSocketPong {
+	ret := &WebSocketPong{}
+	r.message(func(r *binaryReader) {
+		switch r.tag {
+		case 1:
+			ret.PingTime = r.float64()
+		case 2:
+			ret.Time = r.float64()
+		default:
+			r.skip()
+		}
+	})
+	return ret
+}
+
+func encodePlaybackControl(w *binaryWriter, control *WebSocketPlaybackControl) {
+	if control.Paused != nil {
+		w.forceBool(1, *control.Paused)
+	}
+	if control.Speed != nil {
+		w.forceFloat64(2, *control.Speed)
+	}
+	if control.Seek != nil {
+		w.forceUvarint(3, *control.Seek)
+	}
+}
+
+func decodePlaybackControl(r *binaryReader, control *WebSocketPlaybackControl) {
+	switch r.tag {
+	case 1:
+		paused := r.bool()
+		control.Paused = &paused
+	case 2:
+		speed := r.float64()
+		control.Speed = &speed
+	case 3:
+		seek := r.uvarint()
+		control.Seek = &seek
+	default:
+		r.skip()
+	}
+}
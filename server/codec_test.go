@@ -0,0 +1,166 @@
+package server
+
+import (
+	"math"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/vmrob/grav-game/game"
+)
+
+func TestCodecs(t *testing.T) {
+	paused := false
+	speed := 0.5
+	seek := uint64(0)
+
+	gameState := &WebSocketGameState{
+		Tick:     1234567,
+		BaseTick: 1234560,
+	}
+	gameState.Universe.Bounds = game.Rect{X: -5000, Y: -5000, W: 10000, H: 10000}
+	gameState.Universe.Bodies = map[string]*WebSocketBody{
+		"0": {
+			MinorName: "Alfa-Bravo-Charlie",
+			MajorName: "Aldebaran",
+			Position:  WebSocketPoint{-1234.56, 0.01},
+			Mass:      123456.78,
+			Radius:    12.34,
+			NetForce:  WebSocketVector{-0.5, 3e10},
+		},
+		"7": {
+			PlayerName: "Ünïcode 🚀",
+			Color:      "#ff8800",
+		},
+		"12345": {},
+	}
+	gameState.Universe.Removed = []string{"1", "2"}
+	gameState.InputSeq = 99
+
+	emptyState := &WebSocketGameState{}
+	emptyState.Universe.Bodies = map[string]*WebSocketBody{}
+
+	outputs := []*WebSocketOutput{
+		{},
+		{GameState: gameState},
+		{GameState: emptyState},
+		{AssignedBodyId: "42", SessionToken: "abc.def", Room: "default"},
+		{Playback: &WebSocketPlaybackState{Tick: 10, StartTick: 5, EndTick: 100, Paused: true, Speed: 2}},
+		{Playback: &WebSocketPlaybackState{}},
+		{PlayerLeft: &WebSocketPlayerLeft{BodyId: "7"}},
+		{Match: &WebSocketMatchState{Status: MatchCountdown, Players: 3, MinPlayers: 2, Countdown: 9}},
+		{Match: &WebSocketMatchState{}},
+		{Spectating: &WebSocketSpectatorState{BodyId: "3", Rank: 2}},
+		{Chat: []*WebSocketChatMessage{
+			{Channel: ChatGlobal, From: "1", FromName: "Alice", Text: "hi"},
+			{Channel: ChatWhisper, From: "1", To: "2", Team: "red", Text: "psst"},
+		}},
+		{Ping: &WebSocketPing{Time: 1539820800000.25}},
+		{Pong: &WebSocketPong{PingTime: 1539820800000.25, Time: 1539820800005.5}},
+	}
+	inputs := []*WebSocketInput{
+		{},
+		{Thrust: &game.Vector{X: 1, Y: -1}},
+		{Thrust: &game.Vector{}},
+		{Ack: 1234567},
+		{Seq: 1 << 40, Thrust: &game.Vector{X: 1}},
+		{Ping: &WebSocketPing{Time: 1539820800000.25}, Pong: &WebSocketPong{PingTime: 1, Time: 2}},
+		{Playback: &WebSocketPlaybackControl{}},
+		{Playback: &WebSocketPlaybackControl{Paused: &paused, Speed: &speed, Seek: &seek}},
+		{Spectate: &WebSocketSpectateControl{BodyId: "3"}},
+		{Spectate: &WebSocketSpectateControl{Name: "Aldebaran", Rank: 2}},
+		{Chat: &WebSocketChatInput{Channel: ChatWhisper, To: "2", Text: "psst"}},
+	}
+
+	for name, c := range map[string]codec{
+		"json":   jsonCodec{},
+		"binary": binaryCodec{},
+	} {
+		t.Run(name, func(t *testing.T) {
+			for _, msg := range outputs {
+				data, err := c.EncodeOutput(msg)
+				require.NoError(t, err)
+				var decoded WebSocketOutput
+				require.NoError(t, c.DecodeOutput(data, &decoded))
+				assert.Equal(t, msg, &decoded)
+			}
+
+			for _, msg := range inputs {
+				data, err := c.EncodeInput(msg)
+				require.NoError(t, err)
+				var decoded WebSocketInput
+				require.NoError(t, c.DecodeInput(data, &decoded))
+				assert.Equal(t, msg, &decoded)
+			}
+		})
+	}
+}
+
+func TestBinaryCodecErrors(t *testing.T) {
+	var c binaryCodec
+
+	data, err := c.EncodeOutput(&WebSocketOutput{AssignedBodyId: "42"})
+	require.NoError(t, err)
+
+	var msg WebSocketOutput
+	assert.Error(t, c.DecodeOutput(data[:len(data)-1], &msg))
+
+	// the tag is right, but the wire type is wrong
+	assert.Error(t, c.DecodeOutput([]byte{2<<3 | wireVarint, 42}, &msg))
+
+	// unknown fields are skipped
+	var w binaryWriter
+	w.forceString(100, "future")
+	w.forceFloat64(101, 1)
+	w.string(2, "42")
+	msg = WebSocketOutput{}
+	require.NoError(t, c.DecodeOutput(w.buf, &msg))
+	assert.Equal(t, "42", msg.AssignedBodyId)
+}
+
+func TestDecodeNonFiniteInput(t *testing.T) {
+	inputs := []*WebSocketInput{
+		{Thrust: &game.Vector{X: math.NaN()}},
+		{Thrust: &game.Vector{Y: math.Inf(1)}},
+		{Ping: &WebSocketPing{Time: math.Inf(-1)}},
+		{Pong: &WebSocketPong{PingTime: math.NaN()}},
+	}
+	for _, input := range inputs {
+		data, err := binaryCodec{}.EncodeInput(input)
+		require.NoError(t, err)
+		var msg WebSocketInput
+		assert.Error(t, binaryCodec{}.DecodeInput(data, &msg))
+	}
+
+	// json has no way of writing these, but huge numbers are rejected too
+	var msg WebSocketInput
+	assert.Error(t, jsonCodec{}.DecodeInput([]byte(`{"Thrust": {"X": 1e999}}`), &msg))
+	require.NoError(t, jsonCodec{}.DecodeInput([]byte(`{"Thrust": {"X": 1}}`), &msg))
+	assert.Equal(t, 1.0, msg.Thrust.X)
+}
+
+func TestServerBinarySubprotocol(t *testing.T) {
+	s := NewServer(logrus.StandardLogger())
+	defer s.Close()
+
+	client, err := newWebsocketConnection(s, BinarySubprotocol, JSONSubprotocol)
+	require.NoError(t, err)
+	defer client.Close()
+	assert.Equal(t, BinarySubprotocol, client.Subprotocol())
+
+	var c binaryCodec
+	assignedBodyId := ""
+	for i := 0; assignedBodyId == "" && i < 30; i++ {
+		messageType, data, err := client.ReadMessage()
+		require.NoError(t, err)
+		assert.Equal(t, websocket.BinaryMessage, messageType)
+
+		var msg WebSocketOutput
+		require.NoError(t, c.DecodeOutput(data, &msg))
+		assignedBodyId = msg.AssignedBodyId
+	}
+	assert.NotEmpty(t, assignedBodyId)
+}
@@ -0,0 +1,371 @@
+package server
+
+import (
+	"encoding/json"
+	"flag"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/vmrob/grav-game/game"
+)
+
+// Duration is a time.Duration that's written as a string like "1m30s" in
+// config files.
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return errors.Errorf("durations must be strings like \"1m30s\", not %v", string(data))
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// FileConfig is the server's configuration as it's written in a JSON config
+// file. Anything the file leaves out keeps its default.
+type FileConfig struct {
+	// The address the server listens at.
+	Listen     string
+	AdminToken string
+	// If it's empty, a random one is used.
+	SessionSecret         string
+	DisconnectGracePeriod Duration
+	AbandonedBodies       AbandonedBodyPolicy
+	EmptyRoomTimeout      Duration
+	BannedNameWords       []string
+	MaxTickLag            Duration
+	MaxEventQueueFill     float64
+	MaxRooms              int
+	DefaultRoom           FileRoomConfig
+	QuickPlayRoom         FileRoomConfig
+}
+
+// FileRoomConfig is a RoomConfig as it's written in a config file.
+type FileRoomConfig struct {
+	Bounds              game.Rect
+	TickDuration        Duration
+	ThreatSpawnInterval Duration
+	FoodSpawnInterval   Duration
+	Capacity            int
+	MinPlayers          int
+	Countdown           Duration
+	Physics             game.Physics
+}
+
+// FileTuning is a Tuning as it's written in admin requests.
+type FileTuning struct {
+	ThreatSpawnInterval Duration
+	FoodSpawnInterval   Duration
+	Physics             game.Physics
+}
+
+func newFileTuning(t Tuning) FileTuning {
+	return FileTuning{
+		ThreatSpawnInterval: Duration(t.ThreatSpawnInterval),
+		FoodSpawnInterval:   Duration(t.FoodSpawnInterval),
+		Physics:             t.Physics,
+	}
+}
+
+func (t FileTuning) tuning() Tuning {
+	return Tuning{
+		ThreatSpawnInterval: time.Duration(t.ThreatSpawnInterval),
+		FoodSpawnInterval:   time.Duration(t.FoodSpawnInterval),
+		Physics:             t.Physics,
+	}
+}
+
+func DefaultFileConfig() FileConfig {
+	config := DefaultConfig()
+	return FileConfig{
+		Listen:                ":8080",
+		DisconnectGracePeriod: Duration(config.DisconnectGracePeriod),
+		AbandonedBodies:       config.AbandonedBodies,
+		EmptyRoomTimeout:      Duration(config.EmptyRoomTimeout),
+		BannedNameWords:       config.BannedNameWords,
+		MaxTickLag:            Duration(config.MaxTickLag),
+		MaxEventQueueFill:     config.MaxEventQueueFill,
+		MaxRooms:              config.MaxRooms,
+		DefaultRoom:           newFileRoomConfig(config.DefaultRoom),
+		QuickPlayRoom:         newFileRoomConfig(config.QuickPlayRoom),
+	}
+}
+
+func newFileRoomConfig(c RoomConfig) FileRoomConfig {
+	return FileRoomConfig{
+		Bounds:              c.Bounds,
+		TickDuration:        Duration(c.TickDuration),
+		ThreatSpawnInterval: Duration(c.ThreatSpawnInterval),
+		FoodSpawnInterval:   Duration(c.FoodSpawnInterval),
+		Capacity:            c.Capacity,
+		MinPlayers:          c.MinPlayers,
+		Countdown:           Duration(c.Countdown),
+		Physics:             c.Physics,
+	}
+}
+
+func (c FileRoomConfig) roomConfig() RoomConfig {
+	return RoomConfig{
+		Bounds:              c.Bounds,
+		TickDuration:        time.Duration(c.TickDuration),
+		ThreatSpawnInterval: time.Duration(c.ThreatSpawnInterval),
+		FoodSpawnInterval:   time.Duration(c.FoodSpawnInterval),
+		Capacity:            c.Capacity,
+		MinPlayers:          c.MinPlayers,
+		Countdown:           time.Duration(c.Countdown),
+		Physics:             c.Physics,
+	}
+}
+
+// LoadFileConfig reads the config file at path on top of the defaults. If path
+// is empty, the defaults are returned.
+func LoadFileConfig(path string) (FileConfig, error) {
+	ret := DefaultFileConfig()
+	if path == "" {
+		return ret, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return ret, errors.Wrap(err, "unable to open config file")
+	}
+	defer f.Close()
+	return ret, errors.Wrapf(ret.Read(f), "unable to read %v", path)
+}
+
+// Read reads a JSON config file on top of the config. Fields that aren't in
+// the file are left alone, and fields that don't exist are an error so that
+// typos don't go unnoticed.
+func (c *FileConfig) Read(r io.Reader) error {
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(c)
+}
+
+// A setting that can be overridden by an environment variable or a flag as
+// well as the config file.
+type configOverride struct {
+	// The flag's name. The environment variable's name is GRAV_ followed by
+	// this in upper case with underscores, like GRAV_MAX_ROOMS for max-rooms.
+	name  string
+	usage string
+	// Secrets don't get flags, since flags are visible to other users.
+	envOnly bool
+	set     func(c *FileConfig, v string) error
+}
+
+func (o configOverride) env() string {
+	return "GRAV_" + strings.ToUpper(strings.Replace(o.name, "-", "_", -1))
+}
+
+func stringOverride(field func(c *FileConfig) *string) func(c *FileConfig, v string) error {
+	return func(c *FileConfig, v string) error {
+		*field(c) = v
+		return nil
+	}
+}
+
+func durationOverride(field func(c *FileConfig) *Duration) func(c *FileConfig, v string) error {
+	return func(c *FileConfig, v string) error {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+		*field(c) = Duration(d)
+		return nil
+	}
+}
+
+func floatOverride(field func(c *FileConfig) *float64) func(c *FileConfig, v string) error {
+	return func(c *FileConfig, v string) error {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return err
+		}
+		*field(c) = f
+		return nil
+	}
+}
+
+func intOverride(field func(c *FileConfig) *int) func(c *FileConfig, v string) error {
+	return func(c *FileConfig, v string) error {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return err
+		}
+		*field(c) = n
+		return nil
+	}
+}
+
+// Room settings are overridden for both the default and quick play rooms. The
+// file is the only way to set them differently.
+func roomOverride(set func(room *FileRoomConfig, v string) error) func(c *FileConfig, v string) error {
+	return func(c *FileConfig, v string) error {
+		if err := set(&c.DefaultRoom, v); err != nil {
+			return err
+		}
+		return set(&c.QuickPlayRoom, v)
+	}
+}
+
+func roomDurationOverride(field func(room *FileRoomConfig) *Duration) func(c *FileConfig, v string) error {
+	return roomOverride(func(room *FileRoomConfig, v string) error {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+		*field(room) = Duration(d)
+		return nil
+	})
+}
+
+func roomIntOverride(field func(room *FileRoomConfig) *int) func(c *FileConfig, v string) error {
+	return roomOverride(func(room *FileRoomConfig, v string) error {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return err
+		}
+		*field(room) = n
+		return nil
+	})
+}
+
+func physicsOverride(field func(p *game.Physics) *float64) func(c *FileConfig, v string) error {
+	return roomOverride(func(room *FileRoomConfig, v string) error {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return err
+		}
+		*field(&room.Physics) = f
+		return nil
+	})
+}
+
+var configOverrides = []configOverride{
+	{name: "listen", usage: "the address to listen at", set: stringOverride(func(c *FileConfig) *string { return &c.Listen })},
+	{name: "admin-token", usage: "if set, the admin api is enabled and requires this bearer token", set: stringOverride(func(c *FileConfig) *string { return &c.AdminToken })},
+	{name: "session-secret", envOnly: true, set: stringOverride(func(c *FileConfig) *string { return &c.SessionSecret })},
+	{name: "abandoned-bodies", usage: `what happens to a player's body once they've been gone for the grace period, "remove" or "drift"`, set: func(c *FileConfig, v string) error {
+		c.AbandonedBodies = AbandonedBodyPolicy(v)
+		return nil
+	}},
+	{name: "disconnect-grace-period", usage: "how long players have to reconnect", set: durationOverride(func(c *FileConfig) *Duration { return &c.DisconnectGracePeriod })},
+	{name: "empty-room-timeout", usage: "how long a room can be empty before it's shut down", set: durationOverride(func(c *FileConfig) *Duration { return &c.EmptyRoomTimeout })},
+	{name: "max-tick-lag", usage: "/readyz fails if a room's ticks are this far behind", set: durationOverride(func(c *FileConfig) *Duration { return &c.MaxTickLag })},
+	{name: "max-event-queue-fill", usage: "/readyz fails if a room's event queue is this full, from 0 to 1", set: floatOverride(func(c *FileConfig) *float64 { return &c.MaxEventQueueFill })},
+	{name: "max-rooms", usage: "the most rooms that can be open at once", set: intOverride(func(c *FileConfig) *int { return &c.MaxRooms })},
+
+	{name: "room-tick-duration", usage: "how long each room's ticks are", set: roomDurationOverride(func(r *FileRoomConfig) *Duration { return &r.TickDuration })},
+	{name: "room-threat-spawn-interval", usage: "how often threats are spawned, or 0 for never", set: roomDurationOverride(func(r *FileRoomConfig) *Duration { return &r.ThreatSpawnInterval })},
+	{name: "room-food-spawn-interval", usage: "how often food is spawned, or 0 for never", set: roomDurationOverride(func(r *FileRoomConfig) *Duration { return &r.FoodSpawnInterval })},
+	{name: "room-capacity", usage: "quick play doesn't put players in rooms with this many players", set: roomIntOverride(func(r *FileRoomConfig) *int { return &r.Capacity })},
+	{name: "room-min-players", usage: "matches don't start until rooms have this many players", set: roomIntOverride(func(r *FileRoomConfig) *int { return &r.MinPlayers })},
+	{name: "room-countdown", usage: "how long after a room has enough players its match starts", set: roomDurationOverride(func(r *FileRoomConfig) *Duration { return &r.Countdown })},
+
+	{name: "gravitational-constant", set: physicsOverride(func(p *game.Physics) *float64 { return &p.GravitationalConstant })},
+	{name: "thrust-magnitude", set: physicsOverride(func(p *game.Physics) *float64 { return &p.ThrustMagnitude })},
+	{name: "decay-per-step", set: physicsOverride(func(p *game.Physics) *float64 { return &p.DecayPerStep })},
+	{name: "out-of-bounds-decay-per-step", set: physicsOverride(func(p *game.Physics) *float64 { return &p.OutOfBoundsDecayPerStep })},
+	{name: "min-decay-mass", set: physicsOverride(func(p *game.Physics) *float64 { return &p.MinDecayMass })},
+	{name: "min-forced-decay-mass", set: physicsOverride(func(p *game.Physics) *float64 { return &p.MinForcedDecayMass })},
+	{name: "threat-max-mass", set: physicsOverride(func(p *game.Physics) *float64 { return &p.ThreatMaxMass })},
+	{name: "food-min-mass", set: physicsOverride(func(p *game.Physics) *float64 { return &p.FoodMinMass })},
+	{name: "food-max-mass", set: physicsOverride(func(p *game.Physics) *float64 { return &p.FoodMaxMass })},
+}
+
+// ApplyEnv overrides the config with any of the environment variables that are
+// set. Each of the settings ConfigFlags has a flag for has one, named GRAV_
+// followed by the flag's name, like GRAV_MAX_ROOMS for -max-rooms, and the
+// session secret can be set with GRAV_SESSION_SECRET. Room and physics
+// settings apply to both the default and quick play rooms. Room bounds, and
+// settings that differ between the two, can only be set in the config file.
+// lookup is normally os.LookupEnv.
+func (c *FileConfig) ApplyEnv(lookup func(key string) (string, bool)) error {
+	for _, o := range configOverrides {
+		if v, ok := lookup(o.env()); ok {
+			if err := o.set(c, v); err != nil {
+				return errors.Wrapf(err, "invalid %v", o.env())
+			}
+		}
+	}
+	return nil
+}
+
+// ConfigFlags are command line flags that override the config file and
+// environment variables.
+type ConfigFlags struct {
+	flags  *flag.FlagSet
+	values map[string]*string
+}
+
+// NewConfigFlags defines the flags on flags, which still needs to be parsed.
+func NewConfigFlags(flags *flag.FlagSet) *ConfigFlags {
+	ret := &ConfigFlags{
+		flags:  flags,
+		values: make(map[string]*string),
+	}
+	for _, o := range configOverrides {
+		if o.envOnly {
+			continue
+		}
+		usage := o.usage
+		if usage == "" {
+			usage = "overrides the physics setting of the same name"
+		}
+		ret.values[o.name] = flags.String(o.name, "", usage+". overrides "+o.env()+" and the config file")
+	}
+	return ret
+}
+
+// Apply overrides the config with the flags that were given.
+func (f *ConfigFlags) Apply(c *FileConfig) error {
+	var err error
+	f.flags.Visit(func(fl *flag.Flag) {
+		for _, o := range configOverrides {
+			if o.name == fl.Name && err == nil {
+				if setErr := o.set(c, *f.values[o.name]); setErr != nil {
+					err = errors.Wrapf(setErr, "invalid -%v", o.name)
+				}
+			}
+		}
+	})
+	return err
+}
+
+// Config validates the file's configuration and returns it as a Config.
+func (c FileConfig) Config() (Config, error) {
+	if c.Listen == "" {
+		return Config{}, errors.New("the listen address can't be empty")
+	}
+
+	config := DefaultConfig()
+	config.DisconnectGracePeriod = time.Duration(c.DisconnectGracePeriod)
+	config.AbandonedBodies = c.AbandonedBodies
+	config.EmptyRoomTimeout = time.Duration(c.EmptyRoomTimeout)
+	config.BannedNameWords = c.BannedNameWords
+	config.AdminToken = c.AdminToken
+	config.MaxTickLag = time.Duration(c.MaxTickLag)
+	config.MaxEventQueueFill = c.MaxEventQueueFill
+	config.MaxRooms = c.MaxRooms
+	config.DefaultRoom = c.DefaultRoom.roomConfig()
+	config.QuickPlayRoom = c.QuickPlayRoom.roomConfig()
+	if c.SessionSecret != "" {
+		config.SessionSecret = []byte(c.SessionSecret)
+	}
+	return config, config.Validate()
+}
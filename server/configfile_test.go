@@ -0,0 +1,125 @@
+package server
+
+import (
+	"flag"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/vmrob/grav-game/game"
+)
+
+func TestFileConfig(t *testing.T) {
+	config, err := DefaultFileConfig().Config()
+	require.NoError(t, err)
+	assert.Equal(t, DefaultConfig(), config)
+
+	fileConfig := DefaultFileConfig()
+	require.NoError(t, fileConfig.Read(strings.NewReader(`{
+		"Listen": ":9000",
+		"EmptyRoomTimeout": "5m",
+		"DefaultRoom": {
+			"TickDuration": "50ms",
+			"Physics": {"GravitationalConstant": 50}
+		}
+	}`)))
+	assert.Equal(t, ":9000", fileConfig.Listen)
+
+	require.NoError(t, fileConfig.ApplyEnv(func(key string) (string, bool) {
+		v, ok := map[string]string{
+			"GRAV_LISTEN":       ":9001",
+			"GRAV_MAX_TICK_LAG": "1s",
+		}[key]
+		return v, ok
+	}))
+	assert.Equal(t, ":9001", fileConfig.Listen)
+
+	config, err = fileConfig.Config()
+	require.NoError(t, err)
+	assert.Equal(t, 5*time.Minute, config.EmptyRoomTimeout)
+	assert.Equal(t, time.Second, config.MaxTickLag)
+	assert.Equal(t, 50*time.Millisecond, config.DefaultRoom.TickDuration)
+	// everything else keeps its default
+	assert.Equal(t, DefaultConfig().DisconnectGracePeriod, config.DisconnectGracePeriod)
+	assert.Equal(t, DefaultRoomConfig().Bounds, config.DefaultRoom.Bounds)
+	physics := game.DefaultPhysics()
+	physics.GravitationalConstant = 50
+	assert.Equal(t, physics, config.DefaultRoom.Physics)
+	assert.Equal(t, physics, config.DefaultRoom.newUniverse().Physics())
+
+	fileConfig = DefaultFileConfig()
+	assert.Error(t, fileConfig.Read(strings.NewReader(`{"Lisen": ":9000"}`)))
+	assert.Error(t, fileConfig.Read(strings.NewReader(`{"EmptyRoomTimeout": 5}`)))
+	assert.Error(t, fileConfig.ApplyEnv(func(key string) (string, bool) {
+		return "soon", key == "GRAV_EMPTY_ROOM_TIMEOUT"
+	}))
+
+	for _, f := range []func(c *FileConfig){
+		func(c *FileConfig) { c.Listen = "" },
+		func(c *FileConfig) { c.AbandonedBodies = "explode" },
+		func(c *FileConfig) { c.MaxEventQueueFill = 0 },
+		func(c *FileConfig) { c.DefaultRoom.Capacity = 0 },
+		func(c *FileConfig) { c.QuickPlayRoom.Physics.DecayPerStep = -1 },
+	} {
+		fileConfig := DefaultFileConfig()
+		f(&fileConfig)
+		_, err := fileConfig.Config()
+		assert.Error(t, err)
+	}
+}
+
+func TestConfigOverrides(t *testing.T) {
+	fileConfig := DefaultFileConfig()
+	require.NoError(t, fileConfig.Read(strings.NewReader(`{
+		"Listen": ":9000",
+		"MaxRooms": 10,
+		"EmptyRoomTimeout": "5m",
+		"DefaultRoom": {"Capacity": 4},
+		"QuickPlayRoom": {"Capacity": 6}
+	}`)))
+
+	require.NoError(t, fileConfig.ApplyEnv(func(key string) (string, bool) {
+		v, ok := map[string]string{
+			"GRAV_LISTEN":           ":9001",
+			"GRAV_MAX_ROOMS":        "20",
+			"GRAV_ROOM_CAPACITY":    "8",
+			"GRAV_THRUST_MAGNITUDE": "2",
+		}[key]
+		return v, ok
+	}))
+
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	configFlags := NewConfigFlags(flags)
+	assert.Nil(t, flags.Lookup("session-secret"))
+	require.NoError(t, flags.Parse([]string{"-listen", ":9002", "-room-capacity", "12", "-threat-max-mass", "200000"}))
+	require.NoError(t, configFlags.Apply(&fileConfig))
+
+	config, err := fileConfig.Config()
+	require.NoError(t, err)
+	// flags override the environment
+	assert.Equal(t, ":9002", fileConfig.Listen)
+	assert.Equal(t, 12, config.DefaultRoom.Capacity)
+	assert.Equal(t, 12, config.QuickPlayRoom.Capacity)
+	// the environment overrides the file
+	assert.Equal(t, 20, config.MaxRooms)
+	assert.Equal(t, 2.0, config.DefaultRoom.Physics.ThrustMagnitude)
+	assert.Equal(t, 2.0, config.QuickPlayRoom.Physics.ThrustMagnitude)
+	// flags apply where the environment has nothing to say
+	assert.Equal(t, 200000.0, config.DefaultRoom.Physics.ThreatMaxMass)
+	// the file overrides the defaults
+	assert.Equal(t, 5*time.Minute, config.EmptyRoomTimeout)
+	// and everything else keeps its default
+	assert.Equal(t, DefaultConfig().MaxTickLag, config.MaxTickLag)
+	assert.Equal(t, game.DefaultPhysics().GravitationalConstant, config.QuickPlayRoom.Physics.GravitationalConstant)
+
+	flags = flag.NewFlagSet("test", flag.ContinueOnError)
+	configFlags = NewConfigFlags(flags)
+	require.NoError(t, flags.Parse([]string{"-room-countdown", "soon"}))
+	assert.Error(t, configFlags.Apply(&fileConfig))
+	assert.Error(t, fileConfig.ApplyEnv(func(key string) (string, bool) {
+		return "many", key == "GRAV_MAX_ROOMS"
+	}))
+}
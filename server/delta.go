@@ -0,0 +1,120 @@
+package server
+
+import (
+	"math"
+
+	"github.com/vmrob/grav-game/game"
+)
+
+// Clients are sent a full keyframe at least this often, even if they're
+// acknowledging everything.
+const keyframeInterval = 90
+
+// The number of sent states remembered for each client. If a client's last
+// acknowledged tick is older than this, it gets a keyframe.
+const deltaHistorySize = 32
+
+// Bodies are only resent when they've changed by more than these amounts since
+// the state the client acknowledged. Positions are in world units. Everything
+// else is relative.
+const (
+	deltaPositionThreshold = 0.5
+	deltaRelativeThreshold = 0.01
+	deltaForceThreshold    = 0.05
+)
+
+// deltaEncoder keeps track of what a client has been sent so that it can be
+// sent only what's changed.
+type deltaEncoder struct {
+	history      []deltaState
+	lastKeyframe uint64
+}
+
+// deltaState is the state the client has at a tick, assuming it received it.
+// It isn't necessarily the true state since small changes aren't sent.
+type deltaState struct {
+	tick   uint64
+	bodies map[string]*WebSocketBody
+}
+
+// encode creates the game state to send for the given tick. ack is the last
+// tick the client acknowledged, and the result is a delta from it if
+// possible. The bodies map is kept, so it must not be modified afterwards.
+func (e *deltaEncoder) encode(tick, ack uint64, bounds game.Rect, bodies map[string]*WebSocketBody) *WebSocketGameState {
+	ret := &WebSocketGameState{
+		Tick: tick,
+	}
+	ret.Universe.Bounds = bounds
+
+	base := e.state(ack)
+	if base == nil || tick-e.lastKeyframe >= keyframeInterval {
+		ret.Universe.Bodies = bodies
+		e.lastKeyframe = tick
+		e.remember(deltaState{tick, bodies})
+		return ret
+	}
+
+	ret.BaseTick = base.tick
+	ret.Universe.Bodies = make(map[string]*WebSocketBody)
+	state := deltaState{
+		tick:   tick,
+		bodies: make(map[string]*WebSocketBody, len(bodies)),
+	}
+	for id, body := range bodies {
+		if prev, ok := base.bodies[id]; ok && !bodyChanged(prev, body) {
+			state.bodies[id] = prev
+		} else {
+			state.bodies[id] = body
+			ret.Universe.Bodies[id] = body
+		}
+	}
+	for id := range base.bodies {
+		if _, ok := bodies[id]; !ok {
+			ret.Universe.Removed = append(ret.Universe.Removed, id)
+		}
+	}
+	e.remember(state)
+	return ret
+}
+
+// Returns the state sent for the tick, or nil if it's been forgotten.
+func (e *deltaEncoder) state(tick uint64) *deltaState {
+	for i := range e.history {
+		if e.history[i].tick == tick {
+			return &e.history[i]
+		}
+	}
+	return nil
+}
+
+func (e *deltaEncoder) remember(state deltaState) {
+	if len(e.history) >= deltaHistorySize {
+		copy(e.history, e.history[1:])
+		e.history = e.history[:len(e.history)-1]
+	}
+	e.history = append(e.history, state)
+}
+
+// Returns true if the body has changed enough since prev that it should be
+// resent.
+func bodyChanged(prev, body *WebSocketBody) bool {
+	if prev.MinorName != body.MinorName || prev.MajorName != body.MajorName {
+		return true
+	}
+	if prev.PlayerName != body.PlayerName || prev.Color != body.Color {
+		return true
+	}
+	if math.Hypot(float64(body.Position.X-prev.Position.X), float64(body.Position.Y-prev.Position.Y)) > deltaPositionThreshold {
+		return true
+	}
+	if relativeChange(prev.Mass, body.Mass) > deltaRelativeThreshold || relativeChange(prev.Radius, body.Radius) > deltaRelativeThreshold {
+		return true
+	}
+	change := math.Hypot(float64(body.NetForce.X-prev.NetForce.X), float64(body.NetForce.Y-prev.NetForce.Y))
+	magnitude := math.Hypot(float64(prev.NetForce.X), float64(prev.NetForce.Y))
+	return change/math.Max(magnitude, 1) > deltaForceThreshold
+}
+
+func relativeChange(prev, value float32) float64 {
+	return math.Abs(float64(value-prev)) / math.Max(math.Abs(float64(prev)), 1)
+}
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/vmrob/grav-game/game"
+)
+
+// Applies a game state the same way the client does.
+func applyGameState(history map[uint64]map[string]*WebSocketBody, state *WebSocketGameState) map[string]*WebSocketBody {
+	bodies := make(map[string]*WebSocketBody)
+	if state.BaseTick != 0 {
+		base, ok := history[state.BaseTick]
+		if !ok {
+			return nil
+		}
+		for id, body := range base {
+			bodies[id] = body
+		}
+		for _, id := range state.Universe.Removed {
+			delete(bodies, id)
+		}
+	}
+	for id, body := range state.Universe.Bodies {
+		bodies[id] = body
+	}
+	history[state.Tick] = bodies
+	return bodies
+}
+
+func TestDeltaEncoder(t *testing.T) {
+	u := DefaultUniverse()
+	u.Seed(1)
+	for i := 0; i < 50; i++ {
+		u.AddEvent(game.FoodSpawnEvent(u))
+	}
+
+	var encoder deltaEncoder
+	history := make(map[uint64]map[string]*WebSocketBody)
+	var ack uint64
+	keyframes := 0
+	sent, total := 0, 0
+	for i := 0; i < 300; i++ {
+		if i%10 == 0 {
+			u.AddEvent(game.FoodSpawnEvent(u))
+		}
+		u.Step(tickDuration)
+
+		expected := NewWebSocketBodies(u)
+		state := encoder.encode(u.Tick(), ack, u.Bounds(), expected)
+		assert.Equal(t, u.Tick(), state.Tick)
+		if state.BaseTick == 0 {
+			keyframes++
+		} else {
+			assert.Equal(t, ack, state.BaseTick)
+		}
+		sent += len(state.Universe.Bodies)
+		total += len(expected)
+
+		// drop some states entirely and leave some unacknowledged
+		if i%7 == 3 {
+			continue
+		}
+		bodies := applyGameState(history, state)
+		require.NotNil(t, bodies)
+		if i%5 != 1 {
+			ack = state.Tick
+		}
+
+		require.Len(t, bodies, len(expected), "tick %v", u.Tick())
+		for id, body := range expected {
+			require.Contains(t, bodies, id)
+			assert.False(t, bodyChanged(bodies[id], body), "tick %v body %v", u.Tick(), id)
+		}
+	}
+	assert.True(t, keyframes > 1 && keyframes < 10, "%v keyframes", keyframes)
+	assert.True(t, sent < total, "sent %v of %v bodies", sent, total)
+
+	// if the client stops acknowledging for too long, it gets a keyframe
+	for i := 0; i < deltaHistorySize; i++ {
+		u.Step(tickDuration)
+		encoder.encode(u.Tick(), ack, u.Bounds(), NewWebSocketBodies(u))
+	}
+	state := encoder.encode(u.Tick()+1, ack, u.Bounds(), NewWebSocketBodies(u))
+	assert.Zero(t, state.BaseTick)
+}
+
+func TestDeltaEncoderPlayerChanges(t *testing.T) {
+	body := &WebSocketBody{PlayerName: "alice", Color: "#ff0000", Mass: 100}
+
+	var encoder deltaEncoder
+	history := make(map[uint64]map[string]*WebSocketBody)
+	state := encoder.encode(1, 0, game.Rect{}, map[string]*WebSocketBody{"1": body})
+	applyGameState(history, state)
+
+	// abandoned bodies lose their owner, but nothing else about them changes
+	abandoned := *body
+	abandoned.PlayerName = ""
+	abandoned.Color = ""
+	state = encoder.encode(2, 1, game.Rect{}, map[string]*WebSocketBody{"1": &abandoned})
+	assert.Equal(t, uint64(1), state.BaseTick)
+	bodies := applyGameState(history, state)
+	require.Contains(t, bodies, "1")
+	assert.Empty(t, bodies["1"].PlayerName)
+	assert.Empty(t, bodies["1"].Color)
+
+	recolored := *body
+	recolored.Color = "#00ff00"
+	assert.True(t, bodyChanged(body, &recolored))
+}
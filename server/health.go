@@ -0,0 +1,75 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// HealthOutput is the response to a health or readiness check.
+type HealthOutput struct {
+	// "ok", or "unavailable" if the server isn't ready.
+	Status        string
+	UptimeSeconds float64
+	Rooms         int
+	// How far behind schedule the furthest behind room's ticks are.
+	TickLagSeconds float64
+	// Why the server isn't ready.
+	Problems []string `json:",omitempty"`
+}
+
+// Reports that the server is up. This doesn't fail when rooms are struggling
+// since restarting the server wouldn't help. That's what /readyz is for.
+func (s *Server) healthHandler(w http.ResponseWriter, r *http.Request) {
+	output := s.health(time.Now())
+	output.Status = "ok"
+	output.Problems = nil
+	s.writeJSON(w, http.StatusOK, output)
+}
+
+// Reports whether the server is keeping up well enough to take more players.
+func (s *Server) readyHandler(w http.ResponseWriter, r *http.Request) {
+	output := s.health(time.Now())
+	status := http.StatusOK
+	if len(output.Problems) > 0 {
+		status = http.StatusServiceUnavailable
+	}
+	s.writeJSON(w, status, output)
+}
+
+func (s *Server) health(now time.Time) HealthOutput {
+	s.roomsMutex.Lock()
+	rooms := make([]*Room, 0, len(s.rooms))
+	for _, room := range s.rooms {
+		rooms = append(rooms, room)
+	}
+	s.roomsMutex.Unlock()
+	sort.Slice(rooms, func(i, j int) bool {
+		return rooms[i].name < rooms[j].name
+	})
+
+	ret := HealthOutput{
+		Status:        "ok",
+		UptimeSeconds: now.Sub(s.started).Seconds(),
+		Rooms:         len(rooms),
+	}
+	var maxLag time.Duration
+	for _, room := range rooms {
+		lag := room.tickLag(now)
+		if lag > maxLag {
+			maxLag = lag
+		}
+		if lag > s.config.MaxTickLag {
+			ret.Problems = append(ret.Problems, fmt.Sprintf("room %v is %v behind", room.name, lag))
+		}
+		if fill := room.universe.EventQueueStats().Fullest(); fill >= s.config.MaxEventQueueFill {
+			ret.Problems = append(ret.Problems, fmt.Sprintf("room %v's event queue is %.0f%% full", room.name, fill*100))
+		}
+	}
+	ret.TickLagSeconds = maxLag.Seconds()
+	if len(ret.Problems) > 0 {
+		ret.Status = "unavailable"
+	}
+	return ret
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHealth(t *testing.T) {
+	s := NewServer(logrus.StandardLogger())
+	defer s.Close()
+
+	check := func(path string) (int, HealthOutput) {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		var output HealthOutput
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&output))
+		return w.Code, output
+	}
+
+	code, output := check("/readyz")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "ok", output.Status)
+	assert.Equal(t, 1, output.Rooms)
+	assert.Empty(t, output.Problems)
+
+	// pretend the room hasn't ticked in a while
+	atomic.StoreInt64(&s.defaultRoom.lastTickDone, time.Now().Add(-time.Hour).UnixNano())
+	code, output = check("/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "unavailable", output.Status)
+	assert.True(t, output.TickLagSeconds > 3000)
+	assert.Len(t, output.Problems, 1)
+
+	// the server is still up though
+	code, output = check("/healthz")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "ok", output.Status)
+	assert.True(t, output.UptimeSeconds > 0)
+
+	// the room's next tick catches it back up
+	waitForReady := func() bool {
+		for i := 0; i < 100; i++ {
+			if code, _ := check("/readyz"); code == http.StatusOK {
+				return true
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		return false
+	}
+	assert.True(t, waitForReady())
+}
+
+func TestHealthEventQueue(t *testing.T) {
+	config := DefaultConfig()
+	config.MaxEventQueueFill = 0.001
+	s := NewServerWithConfig(logrus.StandardLogger(), config)
+	defer s.Close()
+
+	// block the room so that events pile up
+	blocked, release := make(chan struct{}), make(chan struct{})
+	defer close(release)
+	s.defaultRoom.universe.AddEvent(func() {
+		close(blocked)
+		<-release
+	})
+	<-blocked
+	for i := 0; i < 10; i++ {
+		s.defaultRoom.universe.AddEvent(func() {})
+	}
+
+	output := s.health(time.Now())
+	assert.Equal(t, "unavailable", output.Status)
+	require.NotEmpty(t, output.Problems)
+	assert.Contains(t, output.Problems[len(output.Problems)-1], "event queue")
+}
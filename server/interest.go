@@ -0,0 +1,39 @@
+package server
+
+import (
+	"math"
+)
+
+// Clients are sent the bodies within this many of their own body's radii of
+// it. The client shows about half of this around its body, so bodies are
+// already known by the time they come into view.
+const interestRadiusScale = 64
+
+// The smallest area of interest, so that tiny bodies can still see what's
+// coming for them.
+const minInterestRadius = 500
+
+// filterInterest returns the bodies that a client focused on the given body
+// should be sent: the ones near the focus and the ones with major names,
+// which are relevant to everyone. If focus is nil, only the major bodies are
+// returned.
+func filterInterest(bodies map[string]*WebSocketBody, focus *WebSocketBody) map[string]*WebSocketBody {
+	ret := make(map[string]*WebSocketBody)
+
+	var radius float64
+	if focus != nil {
+		radius = math.Max(float64(focus.Radius)*interestRadiusScale, minInterestRadius)
+	}
+
+	for id, body := range bodies {
+		if body.MajorName != "" {
+			ret[id] = body
+		} else if focus != nil {
+			distance := math.Hypot(float64(body.Position.X-focus.Position.X), float64(body.Position.Y-focus.Position.Y))
+			if distance-float64(body.Radius) <= radius {
+				ret[id] = body
+			}
+		}
+	}
+	return ret
+}
@@ -0,0 +1,35 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilterInterest(t *testing.T) {
+	bodies := map[string]*WebSocketBody{
+		"player": {Position: WebSocketPoint{0, 0}, Radius: 20},
+		"near":   {Position: WebSocketPoint{1000, 0}, Radius: 5},
+		"edge":   {Position: WebSocketPoint{0, 1300}, Radius: 30},
+		"far":    {Position: WebSocketPoint{0, 1400}, Radius: 30},
+		"major":  {Position: WebSocketPoint{4000, 4000}, Radius: 50, MajorName: "Aldebaran"},
+	}
+
+	visible := filterInterest(bodies, bodies["player"])
+	assert.Len(t, visible, 4)
+	assert.Contains(t, visible, "player")
+	assert.Contains(t, visible, "near")
+	assert.Contains(t, visible, "edge")
+	assert.Contains(t, visible, "major")
+
+	// smaller bodies see less, but not too little
+	bodies["player"].Radius = 1
+	visible = filterInterest(bodies, bodies["player"])
+	assert.Len(t, visible, 2)
+	assert.Contains(t, visible, "player")
+	assert.Contains(t, visible, "major")
+
+	visible = filterInterest(bodies, nil)
+	assert.Len(t, visible, 1)
+	assert.Contains(t, visible, "major")
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// LobbyRoom is a room as it's listed in the lobby.
+type LobbyRoom struct {
+	Name       string
+	Players    int
+	Spectators int
+	Capacity   int
+	Match      MatchStatus
+}
+
+// LobbyOutput is the response to a lobby request.
+type LobbyOutput struct {
+	Rooms []LobbyRoom
+}
+
+// Quick play rooms wait for a couple of players before starting.
+func defaultQuickPlayRoomConfig() RoomConfig {
+	ret := DefaultRoomConfig()
+	ret.MinPlayers = 2
+	return ret
+}
+
+// Lists the rooms that aren't full.
+func (s *Server) lobbyHandler(w http.ResponseWriter, r *http.Request) {
+	output := LobbyOutput{
+		Rooms: []LobbyRoom{},
+	}
+	for _, info := range s.Rooms() {
+		if info.Players < info.Config.Capacity {
+			output.Rooms = append(output.Rooms, LobbyRoom{
+				Name:       info.Name,
+				Players:    info.Players,
+				Spectators: info.Spectators,
+				Capacity:   info.Config.Capacity,
+				Match:      info.Match,
+			})
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(output); err != nil {
+		s.logger.Warn(err)
+	}
+}
+
+// Connects the player to whichever room quick play picks for them.
+func (s *Server) quickPlayHandler(w http.ResponseWriter, r *http.Request) {
+	s.connectWebSocket(w, r, false, s.quickPlayRoom)
+}
+
+// Returns the room a quick play player should join, creating one if they're
+// all full. The rooms mutex must be locked.
+func (s *Server) quickPlayRoom() (*Room, error) {
+	infos := make([]RoomInfo, 0, len(s.rooms))
+	for _, room := range s.rooms {
+		infos = append(infos, room.Info())
+	}
+	if info, ok := pickQuickPlayRoom(infos); ok {
+		return s.rooms[info.Name], nil
+	}
+
+	for {
+		s.quickPlayRooms++
+		name := fmt.Sprintf("quickplay-%d", s.quickPlayRooms)
+		if _, ok := s.rooms[name]; !ok {
+			return s.createRoom(name, quickPlayTemplate, s.config.QuickPlayRoom)
+		}
+	}
+}
+
+// Picks the least full of the rooms that have space. Ties go to whichever
+// room's name comes first so that players end up together.
+func pickQuickPlayRoom(rooms []RoomInfo) (RoomInfo, bool) {
+	var ret RoomInfo
+	found := false
+	for _, info := range rooms {
+		if info.Players >= info.Config.Capacity {
+			continue
+		}
+		if !found || info.Players < ret.Players || (info.Players == ret.Players && info.Name < ret.Name) {
+			ret = info
+			found = true
+		}
+	}
+	return ret, found
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPickQuickPlayRoom(t *testing.T) {
+	config := DefaultRoomConfig()
+	config.Capacity = 4
+
+	_, ok := pickQuickPlayRoom(nil)
+	assert.False(t, ok)
+
+	info, ok := pickQuickPlayRoom([]RoomInfo{
+		{Name: "c", Players: 2, Config: config},
+		{Name: "b", Players: 1, Config: config},
+		{Name: "a", Players: 1, Config: config},
+		{Name: "full", Players: 4, Config: config},
+	})
+	assert.True(t, ok)
+	assert.Equal(t, "a", info.Name)
+
+	_, ok = pickQuickPlayRoom([]RoomInfo{
+		{Name: "full", Players: 4, Config: config},
+	})
+	assert.False(t, ok)
+}
+
+func TestLobby(t *testing.T) {
+	config := DefaultConfig()
+	config.QuickPlayRoom.Capacity = 1
+	config.QuickPlayRoom.MinPlayers = 1
+	config.QuickPlayRoom.Countdown = 0
+	s := NewServerWithConfig(logrus.StandardLogger(), config)
+	defer s.Close()
+
+	lobby := func() LobbyOutput {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest("GET", "/lobby", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		var output LobbyOutput
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&output))
+		return output
+	}
+	assert.Equal(t, LobbyOutput{
+		Rooms: []LobbyRoom{
+			{Name: DefaultRoomName, Capacity: 16, Match: MatchStarted},
+		},
+	}, lobby())
+
+	// fill up the default room so that quick play has to make new ones
+	s.defaultRoom.webSocketsMutex.Lock()
+	s.defaultRoom.roomConfig.Capacity = 0
+	s.defaultRoom.webSocketsMutex.Unlock()
+
+	quickPlay := func() (*websocket.Conn, *WebSocketOutput) {
+		client, err := dialWebsocket(s, "/lobby/quickplay")
+		require.NoError(t, err)
+		for {
+			var msg WebSocketOutput
+			require.NoError(t, client.ReadJSON(&msg))
+			if msg.AssignedBodyId != "" {
+				return client, &msg
+			}
+		}
+	}
+
+	first, msg := quickPlay()
+	defer first.Close()
+	assert.Equal(t, "quickplay-1", msg.Room)
+	assert.Equal(t, &WebSocketMatchState{Status: MatchStarted, Players: 1, MinPlayers: 1}, msg.Match)
+
+	second, msg := quickPlay()
+	defer second.Close()
+	assert.Equal(t, "quickplay-2", msg.Room)
+
+	// full rooms aren't listed
+	assert.Empty(t, lobby().Rooms)
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"math"
+	"time"
+)
+
+// MatchStatus is how far along a room's match is.
+type MatchStatus string
+
+const (
+	// The room is waiting for enough players to join.
+	MatchWaiting MatchStatus = "waiting"
+	// There are enough players, and the match starts when the countdown ends.
+	MatchCountdown MatchStatus = "countdown"
+	// Players can move their bodies.
+	MatchStarted MatchStatus = "started"
+)
+
+// match tracks when a room's players are allowed to start playing. Rooms that
+// don't have a minimum player count start right away. Once a match has
+// started, it doesn't stop if players leave.
+//
+// Matches should only be used from the goroutine that steps the universe.
+type match struct {
+	status   MatchStatus
+	startsAt time.Time
+}
+
+func newMatch(config RoomConfig) match {
+	if config.MinPlayers == 0 {
+		return match{status: MatchStarted}
+	}
+	return match{status: MatchWaiting}
+}
+
+// update moves the match along given the number of players in the room.
+func (m *match) update(now time.Time, players int, config RoomConfig) {
+	switch m.status {
+	case MatchWaiting:
+		if players >= config.MinPlayers {
+			m.status = MatchCountdown
+			m.startsAt = now.Add(config.Countdown)
+		}
+	case MatchCountdown:
+		if players < config.MinPlayers {
+			m.status = MatchWaiting
+		}
+	}
+	if m.status == MatchCountdown && !now.Before(m.startsAt) {
+		m.status = MatchStarted
+	}
+}
+
+// state describes the match to players. The countdown is in whole seconds so
+// that it only changes once a second.
+func (m *match) state(now time.Time, players int, config RoomConfig) *WebSocketMatchState {
+	ret := &WebSocketMatchState{
+		Status:     m.status,
+		Players:    players,
+		MinPlayers: config.MinPlayers,
+	}
+	if m.status == MatchCountdown {
+		ret.Countdown = int(math.Ceil(m.startsAt.Sub(now).Seconds()))
+	}
+	return ret
+}
@@ -0,0 +1,43 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatch(t *testing.T) {
+	config := DefaultRoomConfig()
+	config.MinPlayers = 2
+	config.Countdown = 3 * time.Second
+
+	now := time.Now()
+	m := newMatch(config)
+	assert.Equal(t, MatchWaiting, m.status)
+
+	m.update(now, 1, config)
+	assert.Equal(t, MatchWaiting, m.status)
+
+	m.update(now, 2, config)
+	assert.Equal(t, MatchCountdown, m.status)
+	assert.Equal(t, 3, m.state(now.Add(time.Millisecond), 2, config).Countdown)
+
+	// the countdown starts over if someone leaves
+	m.update(now.Add(time.Second), 1, config)
+	assert.Equal(t, MatchWaiting, m.status)
+	now = now.Add(2 * time.Second)
+	m.update(now, 2, config)
+	m.update(now.Add(2*time.Second), 2, config)
+	assert.Equal(t, MatchCountdown, m.status)
+	assert.Equal(t, 1, m.state(now.Add(2*time.Second), 2, config).Countdown)
+
+	// once the match is started, it stays started
+	m.update(now.Add(3*time.Second), 2, config)
+	assert.Equal(t, MatchStarted, m.status)
+	m.update(now.Add(4*time.Second), 0, config)
+	assert.Equal(t, MatchStarted, m.status)
+
+	config.MinPlayers = 0
+	assert.Equal(t, MatchStarted, newMatch(config).status)
+}
@@ -0,0 +1,228 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/vmrob/grav-game/game"
+)
+
+// Metrics are exposed at /metrics in the Prometheus text format. Everything
+// here is shared by all of the process's rooms, and per-room metrics are
+// gathered from the rooms when they're scraped.
+var (
+	// How long each phase of each step takes.
+	stepPhaseSeconds = map[string]*histogram{
+		"decay":      newHistogram(stepPhaseBuckets...),
+		"collisions": newHistogram(stepPhaseBuckets...),
+		"forces":     newHistogram(stepPhaseBuckets...),
+		"integrate":  newHistogram(stepPhaseBuckets...),
+	}
+	// The number of bytes each room's websockets wrote between ticks.
+	tickSentBytes = newHistogram(1<<10, 4<<10, 16<<10, 64<<10, 256<<10, 1<<20, 4<<20)
+
+	merges uint64
+	// The total number of bytes written by websockets, including viewers.
+	sentBytes uint64
+	// The number of messages Send couldn't queue.
+	droppedMessages uint64
+)
+
+var stepPhaseBuckets = []float64{.0001, .00025, .0005, .001, .0025, .005, .01, .025, .05, .1}
+
+// The order the phases are written in.
+var stepPhases = []string{"decay", "collisions", "forces", "integrate"}
+
+// Records a step of any room's universe.
+func observeStep(stats game.StepStats) {
+	stepPhaseSeconds["decay"].observe(stats.Decay.Seconds())
+	stepPhaseSeconds["collisions"].observe(stats.Collisions.Seconds())
+	stepPhaseSeconds["forces"].observe(stats.Forces.Seconds())
+	stepPhaseSeconds["integrate"].observe(stats.Integrate.Seconds())
+	atomic.AddUint64(&merges, uint64(stats.Merges))
+}
+
+// histogram counts observations in buckets, like a Prometheus histogram. It's
+// safe to use from multiple goroutines.
+type histogram struct {
+	mutex sync.Mutex
+	// The upper bounds of each bucket, in ascending order. There's an
+	// implicit +Inf bucket at the end.
+	bounds []float64
+	// The number of observations in each bucket, not including the ones
+	// before it.
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func newHistogram(bounds ...float64) *histogram {
+	return &histogram{
+		bounds: bounds,
+		counts: make([]uint64, len(bounds)+1),
+	}
+}
+
+func (h *histogram) observe(v float64) {
+	i := 0
+	for i < len(h.bounds) && v > h.bounds[i] {
+		i++
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.counts[i]++
+	h.sum += v
+	h.count++
+}
+
+// metricsWriter writes metrics in the Prometheus text format.
+type metricsWriter struct {
+	buf bytes.Buffer
+}
+
+func (m *metricsWriter) header(name, kind, help string) {
+	fmt.Fprintf(&m.buf, "# HELP %v %v\n# TYPE %v %v\n", name, help, name, kind)
+}
+
+// labels are pairs of names and values.
+func (m *metricsWriter) sample(name string, value float64, labels ...string) {
+	m.buf.WriteString(name)
+	if len(labels) > 0 {
+		m.buf.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				m.buf.WriteByte(',')
+			}
+			fmt.Fprintf(&m.buf, "%v=\"%v\"", labels[i], labelEscaper.Replace(labels[i+1]))
+		}
+		m.buf.WriteByte('}')
+	}
+	m.buf.WriteByte(' ')
+	m.buf.WriteString(formatMetricValue(value))
+	m.buf.WriteByte('\n')
+}
+
+func (m *metricsWriter) histogram(name string, h *histogram, labels ...string) {
+	h.mutex.Lock()
+	counts := append([]uint64(nil), h.counts...)
+	sum, count := h.sum, h.count
+	h.mutex.Unlock()
+
+	cumulative := uint64(0)
+	for i, n := range counts {
+		cumulative += n
+		le := "+Inf"
+		if i < len(h.bounds) {
+			le = formatMetricValue(h.bounds[i])
+		}
+		m.sample(name+"_bucket", float64(cumulative), append(labels, "le", le)...)
+	}
+	m.sample(name+"_sum", sum, labels...)
+	m.sample(name+"_count", float64(count), labels...)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatMetricValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var eventPriorities = []game.EventPriority{game.SystemPriority, game.InputPriority, game.SpawnPriority}
+
+func (s *Server) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	var m metricsWriter
+	s.writeMetrics(&m)
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	io.Copy(w, &m.buf)
+}
+
+func (s *Server) writeMetrics(m *metricsWriter) {
+	m.header("grav_step_phase_seconds", "histogram", "How long each phase of a step takes.")
+	for _, phase := range stepPhases {
+		m.histogram("grav_step_phase_seconds", stepPhaseSeconds[phase], "phase", phase)
+	}
+
+	m.header("grav_tick_sent_bytes", "histogram", "Bytes written to a room's websockets between ticks.")
+	m.histogram("grav_tick_sent_bytes", tickSentBytes)
+
+	m.header("grav_merges_total", "counter", "Bodies absorbed by other bodies.")
+	m.sample("grav_merges_total", float64(atomic.LoadUint64(&merges)))
+	m.header("grav_websocket_sent_bytes_total", "counter", "Bytes written to websockets.")
+	m.sample("grav_websocket_sent_bytes_total", float64(atomic.LoadUint64(&sentBytes)))
+	m.header("grav_websocket_dropped_messages_total", "counter", "Outgoing messages dropped because a websocket's queue was full.")
+	m.sample("grav_websocket_dropped_messages_total", float64(atomic.LoadUint64(&droppedMessages)))
+	m.header("grav_slow_client_disconnects_total", "counter", "Clients disconnected for not keeping up.")
+	m.sample("grav_slow_client_disconnects_total", float64(SlowClientDisconnects()))
+
+	s.roomsMutex.Lock()
+	rooms := make([]*Room, 0, len(s.rooms))
+	for _, room := range s.rooms {
+		rooms = append(rooms, room)
+	}
+	s.roomsMutex.Unlock()
+
+	type roomMetrics struct {
+		info    RoomInfo
+		bodies  int
+		tickLag time.Duration
+	}
+	var metrics []roomMetrics
+	now := time.Now()
+	for _, room := range rooms {
+		metrics = append(metrics, roomMetrics{room.Info(), room.universe.State().Len(), room.tickLag(now)})
+	}
+	sort.Slice(metrics, func(i, j int) bool {
+		return metrics[i].info.Name < metrics[j].info.Name
+	})
+
+	m.header("grav_rooms", "gauge", "Open rooms.")
+	m.sample("grav_rooms", float64(len(metrics)))
+
+	m.header("grav_room_tick_lag_seconds", "gauge", "How far behind schedule a room's ticks are.")
+	for _, rm := range metrics {
+		m.sample("grav_room_tick_lag_seconds", rm.tickLag.Seconds(), "room", rm.info.Name)
+	}
+	m.header("grav_room_bodies", "gauge", "Bodies in a room's universe.")
+	for _, rm := range metrics {
+		m.sample("grav_room_bodies", float64(rm.bodies), "room", rm.info.Name)
+	}
+	m.header("grav_room_websockets", "gauge", "Websockets connected to a room.")
+	for _, rm := range metrics {
+		m.sample("grav_room_websockets", float64(rm.info.Players), "room", rm.info.Name, "kind", "player")
+		m.sample("grav_room_websockets", float64(rm.info.Spectators), "room", rm.info.Name, "kind", "spectator")
+	}
+	m.header("grav_room_latency_seconds", "gauge", "Average round trip time of a room's players.")
+	for _, rm := range metrics {
+		m.sample("grav_room_latency_seconds", rm.info.Latency.Seconds(), "room", rm.info.Name)
+	}
+	m.header("grav_room_event_queue_depth", "gauge", "Events waiting to be applied to a room's universe.")
+	for _, rm := range metrics {
+		for _, priority := range eventPriorities {
+			m.sample("grav_room_event_queue_depth", float64(rm.info.Events.Depth[priority]), "room", rm.info.Name, "priority", priority.String())
+		}
+	}
+	m.header("grav_room_events_coalesced_total", "counter", "Events replaced by newer ones before being applied.")
+	for _, rm := range metrics {
+		m.sample("grav_room_events_coalesced_total", float64(rm.info.Events.Coalesced), "room", rm.info.Name)
+	}
+	m.header("grav_room_events_dropped_total", "counter", "Events dropped because the queue was full or their source was over its quota.")
+	for _, rm := range metrics {
+		m.sample("grav_room_events_dropped_total", float64(rm.info.Events.Dropped), "room", rm.info.Name)
+	}
+}
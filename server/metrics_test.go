@@ -0,0 +1,62 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistogram(t *testing.T) {
+	h := newHistogram(1, 10)
+	h.observe(0.5)
+	h.observe(1)
+	h.observe(5)
+	h.observe(100)
+
+	var m metricsWriter
+	m.histogram("test", h, "a", `b"c`)
+	assert.Equal(t, `test_bucket{a="b\"c",le="1"} 2
+test_bucket{a="b\"c",le="10"} 3
+test_bucket{a="b\"c",le="+Inf"} 4
+test_sum{a="b\"c"} 106.5
+test_count{a="b\"c"} 4
+`, m.buf.String())
+}
+
+func TestMetrics(t *testing.T) {
+	s := NewServer(logrus.StandardLogger())
+	defer s.Close()
+
+	client, err := dialWebsocket(s, "/game")
+	require.NoError(t, err)
+	defer client.Close()
+	for i := 0; i < 3; i++ {
+		var msg WebSocketOutput
+		require.NoError(t, client.ReadJSON(&msg))
+	}
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Header().Get("Content-Type"), "text/plain")
+
+	body := w.Body.String()
+	for _, line := range []string{
+		"# TYPE grav_step_phase_seconds histogram",
+		`grav_step_phase_seconds_bucket{phase="collisions",le="+Inf"}`,
+		"# TYPE grav_tick_sent_bytes histogram",
+		"grav_merges_total ",
+		"grav_websocket_sent_bytes_total ",
+		"grav_websocket_dropped_messages_total ",
+		"grav_rooms 1\n",
+		`grav_room_bodies{room="default"}`,
+		`grav_room_websockets{room="default",kind="player"} 1` + "\n",
+		`grav_room_event_queue_depth{room="default",priority="input"}`,
+	} {
+		assert.Contains(t, body, line)
+	}
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// The longest a player's name can be, in characters.
+const maxPlayerNameLength = 20
+
+// Words that can't appear anywhere in a player's name, so that players can't
+// pass themselves off as staff. More can be added with Config.BannedNameWords.
+var defaultBannedNameWords = []string{"admin", "moderator", "server"}
+
+var playerColorRegexp = regexp.MustCompile(`^#([0-9a-f]{3}|[0-9a-f]{6})$`)
+
+// identity is how a player asked to appear to everyone else. All of the
+// fields are optional.
+type identity struct {
+	name  string
+	color string
+	team  string
+}
+
+// newIdentity validates a name, color, and team supplied by a player,
+// returning them cleaned up. Teams follow the same rules as names.
+func newIdentity(name, color, team string, bannedWords []string) (identity, error) {
+	name, err := validatePlayerName(name, bannedWords)
+	if err != nil {
+		return identity{}, err
+	}
+	team, err = validatePlayerName(team, bannedWords)
+	if err != nil {
+		return identity{}, errors.Wrap(err, "invalid team")
+	}
+	color = strings.ToLower(color)
+	if color != "" && !playerColorRegexp.MatchString(color) {
+		return identity{}, errors.Errorf("invalid color %q", color)
+	}
+	return identity{
+		name:  name,
+		color: color,
+		team:  team,
+	}, nil
+}
+
+// validatePlayerName trims the name and checks that it's printable, short
+// enough, and free of banned words. Empty names are fine.
+func validatePlayerName(name string, bannedWords []string) (string, error) {
+	name = strings.TrimSpace(name)
+	if utf8.RuneCountInString(name) > maxPlayerNameLength {
+		return "", errors.Errorf("names can't be longer than %v characters", maxPlayerNameLength)
+	}
+	for _, r := range name {
+		if !unicode.IsPrint(r) {
+			return "", errors.New("names can only contain printable characters")
+		}
+	}
+
+	lower := strings.ToLower(name)
+	for _, word := range bannedWords {
+		if word != "" && strings.Contains(lower, strings.ToLower(word)) {
+			return "", errors.New("name contains a banned word")
+		}
+	}
+	return name, nil
+}
+
+// uniquePlayerName returns the name, numbered if necessary so that taken
+// returns false for it. Names are compared without regard to case.
+func uniquePlayerName(name string, taken func(lower string) bool) string {
+	if name == "" || !taken(strings.ToLower(name)) {
+		return name
+	}
+	for n := 2; ; n++ {
+		suffix := " " + strconv.Itoa(n)
+		base := []rune(name)
+		if len(base)+len(suffix) > maxPlayerNameLength {
+			base = base[:maxPlayerNameLength-len(suffix)]
+		}
+		candidate := string(base) + suffix
+		if !taken(strings.ToLower(candidate)) {
+			return candidate
+		}
+	}
+}
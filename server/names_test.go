@@ -0,0 +1,92 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlayerNames(t *testing.T) {
+	for name, expected := range map[string]string{
+		"":                        "",
+		"  Bob  ":                 "Bob",
+		"Ünïcode 🚀":               "Ünïcode 🚀",
+		"01234567890123456789":    "01234567890123456789",
+		"012345678901234567890":   "",
+		"Bob\x00":                 "",
+		"The ADMINistrator":       "",
+		"not a moderator, really": "",
+	} {
+		id, err := newIdentity(name, "", "", defaultBannedNameWords)
+		if expected == "" && name != "" {
+			assert.Error(t, err, name)
+		} else {
+			assert.NoError(t, err, name)
+			assert.Equal(t, expected, id.name)
+		}
+	}
+
+	for color, valid := range map[string]bool{
+		"":        true,
+		"#fff":    true,
+		"#FF8800": true,
+		"#ff88":   false,
+		"red":     false,
+	} {
+		id, err := newIdentity("", color, "", nil)
+		if valid {
+			assert.NoError(t, err, color)
+			assert.Equal(t, strings.ToLower(color), id.color)
+		} else {
+			assert.Error(t, err, color)
+		}
+	}
+
+	taken := map[string]bool{"bob": true, "bob 2": true, "01234567890123456789": true}
+	isTaken := func(lower string) bool {
+		return taken[lower]
+	}
+	assert.Equal(t, "alice", uniquePlayerName("alice", isTaken))
+	assert.Equal(t, "BOB 3", uniquePlayerName("BOB", isTaken))
+	assert.Equal(t, "012345678901234567 2", uniquePlayerName("01234567890123456789", isTaken))
+}
+
+func TestServerPlayerNames(t *testing.T) {
+	s := NewServer(logrus.StandardLogger())
+	defer s.Close()
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/game?name=admin", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	join := func() (*websocket.Conn, *WebSocketBody) {
+		client, err := dialWebsocket(s, "/game?name=Bob&color=%2300ff00")
+		require.NoError(t, err)
+		var bodyId string
+		for {
+			var msg WebSocketOutput
+			require.NoError(t, client.ReadJSON(&msg))
+			if msg.AssignedBodyId != "" {
+				bodyId = msg.AssignedBodyId
+			}
+			if msg.GameState != nil && msg.GameState.Universe.Bodies[bodyId] != nil {
+				return client, msg.GameState.Universe.Bodies[bodyId]
+			}
+		}
+	}
+
+	first, body := join()
+	defer first.Close()
+	assert.Equal(t, "Bob", body.PlayerName)
+	assert.Equal(t, "#00ff00", body.Color)
+
+	second, body := join()
+	defer second.Close()
+	assert.Equal(t, "Bob 2", body.PlayerName)
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// How often the server pings each websocket to measure its latency.
+const pingInterval = 2 * time.Second
+
+// Each latency sample moves the smoothed latency this fraction of the way
+// towards it, like TCP's smoothed round trip time.
+const latencySmoothing = 0.125
+
+// Times in pings and pongs are milliseconds since the Unix epoch, which is
+// what browsers' clocks use.
+func unixMillis(t time.Time) float64 {
+	return float64(t.UnixNano()) / float64(time.Millisecond)
+}
+
+func fromUnixMillis(ms float64) time.Time {
+	return time.Unix(0, int64(ms*float64(time.Millisecond)))
+}
+
+// Answers a ping from the client right away so that the client can work out
+// the round trip time and the offset between the clocks.
+func (ws *WebSocket) handlePing(ping *WebSocketPing) {
+	ws.Send(&WebSocketOutput{
+		Pong: &WebSocketPong{
+			PingTime: ping.Time,
+			Time:     unixMillis(time.Now()),
+		},
+	})
+}
+
+// Folds the round trip time of one of the server's pings into the smoothed
+// latency. This is only called from the read goroutine.
+func (ws *WebSocket) handlePong(pong *WebSocketPong) {
+	sample := time.Since(fromUnixMillis(pong.PingTime))
+	if sample < 0 || sample > time.Minute {
+		// the client's made this up
+		return
+	}
+
+	latency := ws.Latency()
+	if latency == 0 {
+		latency = sample
+	} else {
+		latency += time.Duration(float64(sample-latency) * latencySmoothing)
+	}
+	atomic.StoreInt64(&ws.latency, int64(latency))
+	ws.logger.WithField("latency", latency).Debug("measured websocket latency")
+}
+
+// Latency returns the smoothed round trip time to the client, or zero if it
+// hasn't been measured yet.
+func (ws *WebSocket) Latency() time.Duration {
+	return time.Duration(atomic.LoadInt64(&ws.latency))
+}
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLatency(t *testing.T) {
+	ws := &WebSocket{logger: logrus.StandardLogger()}
+	assert.Zero(t, ws.Latency())
+
+	now := time.Now()
+	ws.handlePong(&WebSocketPong{PingTime: unixMillis(now.Add(-100 * time.Millisecond))})
+	assert.InDelta(t, 100*time.Millisecond, ws.Latency(), float64(20*time.Millisecond))
+
+	// later samples are smoothed
+	ws.handlePong(&WebSocketPong{PingTime: unixMillis(now.Add(-900 * time.Millisecond))})
+	assert.InDelta(t, 200*time.Millisecond, ws.Latency(), float64(20*time.Millisecond))
+
+	// nonsense is ignored
+	ws.handlePong(&WebSocketPong{PingTime: unixMillis(now.Add(time.Hour))})
+	ws.handlePong(&WebSocketPong{})
+	assert.InDelta(t, 200*time.Millisecond, ws.Latency(), float64(20*time.Millisecond))
+}
+
+func TestServerPing(t *testing.T) {
+	s := NewServer(logrus.StandardLogger())
+	defer s.Close()
+
+	client, err := newWebsocketConnection(s)
+	require.NoError(t, err)
+	defer client.Close()
+
+	sent := unixMillis(time.Now())
+	require.NoError(t, client.WriteJSON(&WebSocketInput{
+		Ping: &WebSocketPing{Time: sent},
+	}))
+
+	var pong *WebSocketPong
+	for i := 0; pong == nil && i < 100; i++ {
+		var msg WebSocketOutput
+		require.NoError(t, client.ReadJSON(&msg))
+		pong = msg.Pong
+	}
+	require.NotNil(t, pong)
+	assert.Equal(t, sent, pong.PingTime)
+	assert.InDelta(t, unixMillis(time.Now()), pong.Time, 1000)
+}
+
+func TestServerPingWhileClosing(t *testing.T) {
+	s := NewServer(logrus.StandardLogger())
+
+	client, err := newWebsocketConnection(s)
+	require.NoError(t, err)
+	defer client.Close()
+	ws := findWebSocket(t, s.defaultRoom)
+
+	// the server answers pings from the read loop, so this keeps it sending
+	// while the websocket is being closed
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			err := client.WriteJSON(&WebSocketInput{
+				Ping: &WebSocketPing{Time: unixMillis(time.Now())},
+			})
+			if err != nil {
+				return
+			}
+		}
+	}()
+	go func() {
+		for {
+			if _, _, err := client.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	require.NoError(t, s.Close())
+	client.Close()
+	<-done
+
+	// a ping read just before the connection closed is still answered, but
+	// the answer goes nowhere
+	ws.handlePing(&WebSocketPing{Time: unixMillis(time.Now())})
+}
@@ -0,0 +1,198 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	"github.com/satori/go.uuid"
+	"github.com/sirupsen/logrus"
+
+	"github.com/vmrob/grav-game/game"
+)
+
+const maxPlaybackSpeed = 16.0
+
+// ReplayServer plays back a recording over the same websocket protocol as
+// Server, so that the client can be used to watch old matches. Each
+// connection gets its own playback, which it can pause, seek, and speed up or
+// slow down independently of the others.
+type ReplayServer struct {
+	logger       logrus.FieldLogger
+	recording    *game.Recording
+	router       *mux.Router
+	viewers      map[*replayViewer]struct{}
+	viewersMutex sync.Mutex
+}
+
+func NewReplayServer(logger logrus.FieldLogger, recording *game.Recording) *ReplayServer {
+	ret := &ReplayServer{
+		logger:    logger,
+		recording: recording,
+		router:    mux.NewRouter(),
+		viewers:   make(map[*replayViewer]struct{}),
+	}
+	ret.router.HandleFunc("/", indexHandler)
+	ret.router.HandleFunc("/game", ret.gameHandler)
+	ret.router.NotFoundHandler = http.FileServer(http.Dir("dist"))
+	return ret
+}
+
+// Close closes any hijacked connections.
+func (s *ReplayServer) Close() error {
+	var closers []io.Closer
+
+	s.viewersMutex.Lock()
+	for v := range s.viewers {
+		closers = append(closers, v)
+	}
+	s.viewersMutex.Unlock()
+
+	for _, closer := range closers {
+		closer.Close()
+	}
+	return nil
+}
+
+func (s *ReplayServer) gameHandler(w http.ResponseWriter, r *http.Request) {
+	player, err := game.NewPlayer(s.recording, DefaultUniverse())
+	if err != nil {
+		s.logger.Error(err)
+		http.Error(w, "unable to play recording", http.StatusInternalServerError)
+		return
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		s.logger.Warn(err)
+		return
+	}
+	conn.EnableWriteCompression(true)
+
+	logger := s.logger.WithField("connection_id", uuid.NewV4())
+	logger.Info("accepted replay websocket connection")
+
+	v := newReplayViewer(logger, conn, player)
+
+	s.viewersMutex.Lock()
+	defer s.viewersMutex.Unlock()
+	s.viewers[v] = struct{}{}
+
+	go func() {
+		v.run()
+		s.viewersMutex.Lock()
+		defer s.viewersMutex.Unlock()
+		delete(s.viewers, v)
+	}()
+}
+
+func (s *ReplayServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.router.ServeHTTP(w, r)
+}
+
+// replayViewer plays back a recording for a single connection.
+type replayViewer struct {
+	logger   logrus.FieldLogger
+	ws       *WebSocket
+	player   *game.Player
+	controls chan WebSocketPlaybackControl
+	stop     chan struct{}
+	stopped  chan struct{}
+}
+
+func newReplayViewer(logger logrus.FieldLogger, conn *websocket.Conn, player *game.Player) *replayViewer {
+	ret := &replayViewer{
+		logger:   logger,
+		player:   player,
+		controls: make(chan WebSocketPlaybackControl, 10),
+		stop:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	ret.ws = newViewerWebSocket(logger, conn, func(msg *WebSocketInput) {
+		if msg.Playback != nil {
+			select {
+			case ret.controls <- *msg.Playback:
+			default:
+				logger.Warn("dropping playback control")
+			}
+		}
+	})
+	return ret
+}
+
+func (v *replayViewer) run() {
+	defer close(v.stopped)
+	defer v.ws.Close()
+
+	ticker := time.NewTicker(tickDuration)
+	defer ticker.Stop()
+
+	paused := false
+	speed := 1.0
+	var unplayed time.Duration
+	lastTick := time.Now()
+
+	for {
+		select {
+		case <-v.stop:
+			return
+		case control := <-v.controls:
+			if control.Paused != nil {
+				paused = *control.Paused
+			}
+			if control.Speed != nil && *control.Speed > 0 {
+				speed = *control.Speed
+				if speed > maxPlaybackSpeed {
+					speed = maxPlaybackSpeed
+				}
+			}
+			if control.Seek != nil {
+				if err := v.player.Seek(*control.Seek); err != nil {
+					v.logger.Error(err)
+					return
+				}
+				unplayed = 0
+			}
+		case now := <-ticker.C:
+			if !v.ws.IsAlive() {
+				return
+			}
+
+			if !paused {
+				unplayed += time.Duration(float64(now.Sub(lastTick)) * speed)
+				for unplayed >= v.player.StepSize() && !v.player.Done() {
+					if err := v.player.Step(); err != nil {
+						v.logger.Error(err)
+						return
+					}
+					unplayed -= v.player.StepSize()
+				}
+				// don't let time pile up while waiting at the end
+				if v.player.Done() {
+					unplayed = 0
+				}
+			}
+			lastTick = now
+
+			v.ws.SendLatest(&WebSocketOutput{
+				GameState: NewWebSocketGameState(v.player.Universe()),
+				Playback: &WebSocketPlaybackState{
+					Tick:      v.player.Tick(),
+					StartTick: v.player.StartTick(),
+					EndTick:   v.player.EndTick(),
+					Paused:    paused,
+					Speed:     speed,
+				},
+			})
+		}
+	}
+}
+
+func (v *replayViewer) Close() error {
+	close(v.stop)
+	<-v.stopped
+	return nil
+}
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/vmrob/grav-game/game"
+)
+
+func TestReplayServer(t *testing.T) {
+	u := DefaultUniverse()
+	u.Seed(1)
+	u.StartRecording()
+	counts := make(map[uint64]int)
+	for i := 0; i < 100; i++ {
+		u.AddEvent(game.FoodSpawnEvent(u))
+		u.Step(tickDuration)
+		counts[u.Tick()] = len(u.Bodies())
+	}
+	recording := u.StopRecording()
+
+	s := NewReplayServer(logrus.StandardLogger(), recording)
+	defer s.Close()
+
+	client, err := newWebsocketConnection(s)
+	require.NoError(t, err)
+	defer client.Close()
+
+	var msg WebSocketOutput
+	require.NoError(t, client.ReadJSON(&msg))
+	require.NotNil(t, msg.Playback)
+	assert.Equal(t, recording.Initial.Tick, msg.Playback.StartTick)
+	assert.Equal(t, recording.EndTick, msg.Playback.EndTick)
+	assert.NotNil(t, msg.GameState)
+
+	paused := true
+	seek := recording.Initial.Tick + 50
+	require.NoError(t, client.WriteJSON(&WebSocketInput{
+		Playback: &WebSocketPlaybackControl{
+			Paused: &paused,
+			Seek:   &seek,
+		},
+	}))
+
+	for i := 0; i < 30 && !msg.Playback.Paused; i++ {
+		msg = WebSocketOutput{}
+		require.NoError(t, client.ReadJSON(&msg))
+	}
+	require.True(t, msg.Playback.Paused)
+	assert.Equal(t, seek, msg.Playback.Tick)
+	assert.Len(t, msg.GameState.Universe.Bodies, counts[seek])
+}
@@ -0,0 +1,590 @@
+package server
+
+import (
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/vmrob/grav-game/game"
+)
+
+// RoomConfig is the configuration of a single room.
+type RoomConfig struct {
+	Bounds       game.Rect
+	TickDuration time.Duration
+	// How often threats and food are spawned. Zero disables them.
+	ThreatSpawnInterval time.Duration
+	FoodSpawnInterval   time.Duration
+	// Quick play doesn't put players in rooms that have this many players.
+	// Players can still join them directly.
+	Capacity int
+	// The match doesn't start until this many players are in the room. If
+	// it's zero, the match starts right away.
+	MinPlayers int
+	// How long after the room has enough players the match starts.
+	Countdown time.Duration
+	Physics   game.Physics
+}
+
+func DefaultRoomConfig() RoomConfig {
+	return RoomConfig{
+		Bounds:              game.Rect{X: -5000, Y: -5000, W: 10000, H: 10000},
+		TickDuration:        tickDuration,
+		ThreatSpawnInterval: threatSpawnInterval,
+		FoodSpawnInterval:   foodSpawnInterval,
+		Capacity:            16,
+		Countdown:           10 * time.Second,
+		Physics:             game.DefaultPhysics(),
+	}
+}
+
+func (c RoomConfig) validate() error {
+	if c.Bounds.W <= 0 || c.Bounds.H <= 0 {
+		return errors.New("room bounds must have a positive size")
+	}
+	if c.TickDuration <= 0 {
+		return errors.New("room tick duration must be positive")
+	}
+	if c.ThreatSpawnInterval < 0 || c.FoodSpawnInterval < 0 {
+		return errors.New("room spawn intervals can't be negative")
+	}
+	if c.Capacity <= 0 {
+		return errors.New("room capacity must be positive")
+	}
+	if c.MinPlayers < 0 || c.MinPlayers > c.Capacity {
+		return errors.New("room minimum players must be between zero and the capacity")
+	}
+	if c.Countdown < 0 {
+		return errors.New("room countdown can't be negative")
+	}
+	return errors.Wrap(c.Physics.Validate(), "invalid room physics")
+}
+
+func (c RoomConfig) newUniverse() *game.Universe {
+	u := game.NewUniverse(c.Bounds)
+	u.SetGravitySolver(game.NewBarnesHutGravity(gravityOpeningAngle))
+	u.SetIntegrator(&game.VelocityVerlet{})
+	u.SetFixedStep(c.TickDuration)
+	u.SetPhysics(c.Physics)
+	return u
+}
+
+var roomNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,32}$`)
+
+func validRoomName(name string) bool {
+	return roomNameRegexp.MatchString(name)
+}
+
+// RoomInfo describes a room.
+type RoomInfo struct {
+	Name string
+	// The number of connected players. Spectators aren't included.
+	Players    int
+	Spectators int
+	// The average of the players' smoothed latencies.
+	Latency time.Duration
+	Match   MatchStatus
+	Config  RoomConfig
+	// The state of the universe's event queue.
+	Events game.EventQueueStats
+}
+
+// Room runs a universe and the websockets of everyone playing in it.
+type Room struct {
+	name            string
+	logger          logrus.FieldLogger
+	config          Config
+	roomConfig      RoomConfig
+	universe        *game.Universe
+	webSockets      map[*WebSocket]struct{}
+	webSocketsMutex sync.Mutex
+	// Every player's session by id, including ones that have disconnected and
+	// are waiting out the grace period. It's only used by the run goroutine.
+	sessions map[string]*session
+	match    match
+	// The match's status, for other goroutines. It's guarded by the
+	// websockets mutex.
+	matchStatus MatchStatus
+	// Set by the admin API. While the room is paused, events are still
+	// applied, but the universe only steps when the admin API asks it to.
+	// It's only written by the run goroutine, and it's guarded by the
+	// websockets mutex for other goroutines.
+	paused bool
+	// The number of steps the admin API has asked for. It's only used by the
+	// run goroutine.
+	stepsRequested int
+	// The match state players were last sent.
+	lastMatchState WebSocketMatchState
+	// Recent global and team chat messages. It's only used by the run
+	// goroutine.
+	chatHistory []*WebSocketChatMessage
+	// When the room last had nobody in it. It's zero while it's occupied.
+	emptySince time.Time
+	// Called by the run goroutine once the room has been empty for long
+	// enough. If it returns true, the room shuts down.
+	onEmpty        func(r *Room) bool
+	startRecording chan struct{}
+	retune         chan retuneRequest
+	stop           chan struct{}
+	stopped        chan struct{}
+	// The number of bytes written to the room's websockets since the last
+	// tick. It's accessed atomically.
+	sentBytes uint64
+	// When the run goroutine last finished a tick, in Unix nanoseconds. It's
+	// accessed atomically.
+	lastTickDone int64
+	// Which of the server's room configurations the room was created from,
+	// if any. It's guarded by the server's rooms mutex.
+	template string
+}
+
+func newRoom(logger logrus.FieldLogger, name string, config Config, roomConfig RoomConfig, universe *game.Universe, onEmpty func(r *Room) bool) *Room {
+	ret := &Room{
+		name:           name,
+		logger:         logger.WithField("room", name),
+		config:         config,
+		roomConfig:     roomConfig,
+		universe:       universe,
+		webSockets:     make(map[*WebSocket]struct{}),
+		sessions:       make(map[string]*session),
+		match:          newMatch(roomConfig),
+		onEmpty:        onEmpty,
+		startRecording: make(chan struct{}),
+		retune:         make(chan retuneRequest),
+		stop:           make(chan struct{}),
+		stopped:        make(chan struct{}),
+	}
+	ret.matchStatus = ret.match.status
+	ret.lastTickDone = time.Now().UnixNano()
+	universe.SetStepObserver(observeStep)
+	go ret.run()
+	return ret
+}
+
+func (r *Room) Name() string {
+	return r.name
+}
+
+// Info describes the room. It's safe to call from any goroutine.
+func (r *Room) Info() RoomInfo {
+	r.webSocketsMutex.Lock()
+	defer r.webSocketsMutex.Unlock()
+	ret := RoomInfo{
+		Name:   r.name,
+		Match:  r.matchStatus,
+		Config: r.roomConfig,
+		Events: r.universe.EventQueueStats(),
+	}
+	var latency time.Duration
+	measured := 0
+	for ws := range r.webSockets {
+		if ws.spectator != nil {
+			ret.Spectators++
+			continue
+		}
+		ret.Players++
+		if l := ws.Latency(); l > 0 {
+			latency += l
+			measured++
+		}
+	}
+	if measured > 0 {
+		ret.Latency = latency / time.Duration(measured)
+	}
+	return ret
+}
+
+// tickLag returns how far behind schedule the room's ticks are.
+func (r *Room) tickLag(now time.Time) time.Duration {
+	r.webSocketsMutex.Lock()
+	interval := r.roomConfig.TickDuration
+	r.webSocketsMutex.Unlock()
+
+	lag := now.Sub(time.Unix(0, atomic.LoadInt64(&r.lastTickDone))) - interval
+	if lag < 0 {
+		return 0
+	}
+	return lag
+}
+
+// Returns a channel that ticks every interval, or nil if the interval is zero.
+func newTickerChannel(interval time.Duration) (<-chan time.Time, func()) {
+	if interval <= 0 {
+		return nil, func() {}
+	}
+	ticker := time.NewTicker(interval)
+	return ticker.C, ticker.Stop
+}
+
+func (r *Room) run() {
+	defer close(r.stopped)
+
+	tickTicker := time.NewTicker(r.roomConfig.TickDuration)
+	threats, stopThreats := newTickerChannel(r.roomConfig.ThreatSpawnInterval)
+	food, stopFood := newTickerChannel(r.roomConfig.FoodSpawnInterval)
+	defer func() {
+		stopThreats()
+		stopFood()
+	}()
+	defer tickTicker.Stop()
+
+	// Starting half a tick behind keeps the universe's leftover time away from
+	// a step boundary, so ticker jitter doesn't cause ticks to be skipped or
+	// doubled up.
+	lastTick := time.Now().Add(-r.roomConfig.TickDuration / 2)
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-r.startRecording:
+			// this happens here rather than in an event so that the recording
+			// starts at a tick boundary
+			r.universe.StartRecording()
+		case req := <-r.retune:
+			// like recordings, retuning happens at a tick boundary
+			old := r.applyTuning(req.tuning)
+			if req.tuning.ThreatSpawnInterval != old.ThreatSpawnInterval {
+				stopThreats()
+				threats, stopThreats = newTickerChannel(req.tuning.ThreatSpawnInterval)
+			}
+			if req.tuning.FoodSpawnInterval != old.FoodSpawnInterval {
+				stopFood()
+				food, stopFood = newTickerChannel(req.tuning.FoodSpawnInterval)
+			}
+			close(req.done)
+		case <-threats:
+			r.spawn(game.ThreatSpawnEvent(r.universe))
+		case <-food:
+			r.spawn(game.FoodSpawnEvent(r.universe))
+		case now := <-tickTicker.C:
+			r.tick(now, now.Sub(lastTick))
+			lastTick = now
+			atomic.StoreInt64(&r.lastTickDone, time.Now().UnixNano())
+
+			if r.isEmpty() {
+				if r.emptySince.IsZero() {
+					r.emptySince = now
+				} else if now.Sub(r.emptySince) >= r.config.EmptyRoomTimeout && r.onEmpty(r) {
+					r.logger.Info("shutting down empty room")
+					return
+				}
+			} else {
+				r.emptySince = time.Time{}
+			}
+		}
+	}
+}
+
+// Queues a spawn event. Spawns are dropped if too many are already waiting,
+// which only happens if the room is falling behind.
+func (r *Room) spawn(f func()) {
+	err := r.universe.QueueEvent(game.Event{
+		Source:   "spawner",
+		Priority: game.SpawnPriority,
+		Apply:    f,
+	})
+	if err != nil {
+		r.logger.WithError(err).Warn("dropping spawn")
+	}
+}
+
+// Returns true if nobody is in the room, including spectators. It's called
+// from the run goroutine.
+func (r *Room) isEmpty() bool {
+	r.webSocketsMutex.Lock()
+	defer r.webSocketsMutex.Unlock()
+	return len(r.webSockets) == 0 && len(r.sessions) == 0
+}
+
+// Steps the universe for the elapsed time or, if the room is paused, as many
+// times as the admin API asked for. Returns the number of steps taken.
+func (r *Room) advance(elapsed time.Duration) int {
+	if !r.paused {
+		return r.universe.Advance(elapsed)
+	}
+	r.universe.ApplyEvents()
+	if r.stepsRequested == 0 {
+		return 0
+	}
+	r.stepsRequested--
+	// anything left over from before the pause is less than a step, so this
+	// takes exactly one
+	return r.universe.Advance(r.roomConfig.TickDuration)
+}
+
+func (r *Room) tick(now time.Time, elapsed time.Duration) {
+	if r.advance(elapsed) == 0 {
+		return
+	}
+
+	tick := r.universe.Tick()
+	bounds := r.universe.Bounds()
+	bodies := NewWebSocketBodies(r.universe)
+	for _, session := range r.sessions {
+		if body, ok := bodies[session.bodyId.String()]; ok && session.hasBody(r.universe) {
+			body.PlayerName = session.name
+			body.Color = session.color
+		}
+	}
+
+	r.webSocketsMutex.Lock()
+	defer r.webSocketsMutex.Unlock()
+
+	var rankings []string
+	rank := func() []string {
+		if rankings == nil {
+			rankings = rankBodies(bodies)
+		}
+		return rankings
+	}
+
+	for ws := range r.webSockets {
+		if !ws.IsAlive() {
+			delete(r.webSockets, ws)
+			// events queued for it may still send to it, which is fine
+			// since sending to a closed websocket does nothing
+			go ws.Close()
+			// the session may have already been taken over by a new
+			// connection, or the websocket may not have joined yet
+			if ws.session != nil && ws.session.ws == ws {
+				ws.session.ws = nil
+				ws.session.disconnectedAt = now
+			}
+			continue
+		}
+
+		if ws.spectator != nil {
+			if state := ws.spectator.update(bodies, rank); state != nil {
+				ws.Send(&WebSocketOutput{
+					Spectating: state,
+				})
+			}
+		} else if ws.session == nil {
+			continue
+		}
+		ws.SendGameState(tick, bounds, bodies)
+	}
+	tickSentBytes.observe(float64(atomic.SwapUint64(&r.sentBytes, 0)))
+
+	for _, session := range r.sessions {
+		if session.ws == nil && now.Sub(session.disconnectedAt) >= r.config.DisconnectGracePeriod {
+			r.abandon(session)
+		}
+	}
+
+	r.updateMatch(now)
+}
+
+// Moves the match along and lets everyone know if anything they can see has
+// changed. The websockets mutex must be locked.
+func (r *Room) updateMatch(now time.Time) {
+	players := r.connectedPlayers()
+	r.match.update(now, players, r.roomConfig)
+	r.matchStatus = r.match.status
+
+	state := r.match.state(now, players, r.roomConfig)
+	if *state == r.lastMatchState {
+		return
+	}
+	r.lastMatchState = *state
+	for ws := range r.webSockets {
+		if ws.session != nil || ws.spectator != nil {
+			ws.Send(&WebSocketOutput{
+				Match: state,
+			})
+		}
+	}
+}
+
+// Returns the number of players whose websockets have joined.
+func (r *Room) connectedPlayers() int {
+	ret := 0
+	for _, session := range r.sessions {
+		if session.ws != nil {
+			ret++
+		}
+	}
+	return ret
+}
+
+// connect adds a player's websocket to the room. If sessionId is the id of one
+// of the room's sessions, the player resumes it and keeps its identity.
+func (r *Room) connect(logger logrus.FieldLogger, conn *websocket.Conn, sessionId string, identity identity) {
+	ws := newPlayerWebSocket(logger.WithField("room", r.name), conn, r)
+
+	r.webSocketsMutex.Lock()
+	r.webSockets[ws] = struct{}{}
+	r.webSocketsMutex.Unlock()
+
+	// this is queued before the websocket starts so that the player always
+	// has a body by the time any of their input is applied
+	err := r.universe.AddEvent(func() {
+		r.join(ws, sessionId, identity)
+	})
+	if err != nil {
+		logger.WithError(err).Error("unable to join room")
+		r.webSocketsMutex.Lock()
+		delete(r.webSockets, ws)
+		r.webSocketsMutex.Unlock()
+		conn.Close()
+		return
+	}
+	ws.start()
+}
+
+// connectSpectator adds a spectator's websocket to the room.
+func (r *Room) connectSpectator(logger logrus.FieldLogger, conn *websocket.Conn) {
+	ws := newSpectatorWebSocket(logger.WithField("room", r.name), conn, r)
+
+	r.webSocketsMutex.Lock()
+	r.webSockets[ws] = struct{}{}
+	match := r.lastMatchState
+	r.webSocketsMutex.Unlock()
+
+	ws.Send(&WebSocketOutput{
+		Room:  r.name,
+		Match: &match,
+	})
+	err := r.universe.AddEvent(func() {
+		if history := r.visibleChatHistory(ws); len(history) > 0 {
+			ws.Send(&WebSocketOutput{
+				Chat: history,
+			})
+		}
+	})
+	if err != nil {
+		logger.WithError(err).Warn("unable to send chat history")
+	}
+	ws.start()
+}
+
+// Gives the websocket a session, resuming the one with the given id if
+// possible and creating a new one otherwise. This is called from an event.
+func (r *Room) join(ws *WebSocket, sessionId string, identity identity) {
+	session, ok := r.sessions[sessionId]
+	if ok && session.hasBody(r.universe) {
+		ws.logger.WithField("session_id", session.id).Info("resuming session")
+	} else {
+		session = newSession()
+		session.name = uniquePlayerName(identity.name, r.playerNameTaken)
+		session.color = identity.color
+		session.team = identity.team
+		session.addBody(r.universe)
+		r.sessions[session.id] = session
+	}
+
+	r.webSocketsMutex.Lock()
+	// a session only has one connection at a time, so if it's being taken
+	// over, the old one gets kicked out
+	if old := session.ws; old != nil && old != ws {
+		old.logger.Info("closing websocket whose session was resumed elsewhere")
+		delete(r.webSockets, old)
+		go old.Close()
+	}
+	session.ws = ws
+	ws.session = session
+	r.updateMatch(time.Now())
+	match := r.lastMatchState
+	r.webSocketsMutex.Unlock()
+
+	ws.Send(&WebSocketOutput{
+		AssignedBodyId: session.bodyId.String(),
+		SessionToken:   signSessionToken(r.config.SessionSecret, session.id),
+		Room:           r.name,
+		Match:          &match,
+		Chat:           r.visibleChatHistory(ws),
+	})
+}
+
+// Returns true if a session in the room has the name, ignoring case. This is
+// called from the run goroutine.
+func (r *Room) playerNameTaken(lower string) bool {
+	for _, session := range r.sessions {
+		if strings.ToLower(session.name) == lower {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the id of the body with the given player name or, failing that,
+// celestial name. This is called from the run goroutine.
+func (r *Room) findBodyByName(name string) (game.BodyId, bool) {
+	lower := strings.ToLower(name)
+	for _, session := range r.sessions {
+		if session.name != "" && strings.ToLower(session.name) == lower && session.hasBody(r.universe) {
+			return session.bodyId, true
+		}
+	}
+	return findBodyByName(r.universe, name)
+}
+
+// Deals with the body of a player that's left, and lets everyone else know
+// they're gone. The websockets mutex must be locked.
+func (r *Room) abandon(session *session) {
+	delete(r.sessions, session.id)
+
+	if session.hasBody(r.universe) {
+		switch r.config.AbandonedBodies {
+		case RemoveAbandonedBodies:
+			r.universe.RemoveBody(session.bodyId)
+		case DriftAbandonedBodies:
+			r.universe.SetThrust(session.bodyId, game.Vector{})
+		}
+	}
+
+	msg := &WebSocketOutput{
+		PlayerLeft: &WebSocketPlayerLeft{
+			BodyId: session.bodyId.String(),
+		},
+	}
+	for ws := range r.webSockets {
+		ws.Send(msg)
+	}
+}
+
+// Close stops the room and closes any hijacked connections. Players' bodies
+// are left alone rather than abandoned, so snapshots and recordings taken
+// afterwards end where play did.
+func (r *Room) Close() error {
+	close(r.stop)
+	<-r.stopped
+
+	var closers []io.Closer
+
+	r.webSocketsMutex.Lock()
+	for ws := range r.webSockets {
+		closers = append(closers, ws)
+	}
+	r.webSocketsMutex.Unlock()
+
+	for _, closer := range closers {
+		closer.Close()
+	}
+	return nil
+}
+
+// Snapshot captures the state of the room's universe. The room must be closed
+// first.
+func (r *Room) Snapshot() *game.Snapshot {
+	return r.universe.Snapshot()
+}
+
+// StartRecording starts recording everything that happens in the universe so
+// that it can be replayed later.
+func (r *Room) StartRecording() {
+	r.startRecording <- struct{}{}
+}
+
+// StopRecording returns everything recorded since StartRecording was called.
+// The room must be closed first.
+func (r *Room) StopRecording() *game.Recording {
+	return r.universe.StopRecording()
+}
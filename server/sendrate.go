@@ -0,0 +1,76 @@
+package server
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Game states are sent to each client every interval ticks, where the
+// interval goes up when the client can't keep up and back down when it can.
+const maxStateInterval = 8
+
+// A state write that takes longer than this counts as the client falling
+// behind.
+const slowStateWrite = tickDuration
+
+// The interval comes back down after this many writes in a row that keep up.
+const fastWritesToSpeedUp = 30
+
+// Clients that are still behind after this long at the longest interval are
+// disconnected.
+const slowClientTimeout = 10 * time.Second
+
+// The number of clients that have been disconnected for being too slow. It's
+// accessed atomically.
+var slowClientDisconnects uint64
+
+// SlowClientDisconnects returns the number of clients that have been
+// disconnected for being too slow to keep up.
+func SlowClientDisconnects() uint64 {
+	return atomic.LoadUint64(&slowClientDisconnects)
+}
+
+// sendRate adapts how often a client is sent game states. It's only used by
+// the write goroutine.
+type sendRate struct {
+	interval   int
+	fastWrites int
+	// When the client started falling behind at the longest interval, or
+	// zero if it isn't.
+	behindSince time.Time
+}
+
+func newSendRate() sendRate {
+	return sendRate{
+		interval: 1,
+	}
+}
+
+// wrote is called after a state is written with how long the write took and
+// how many states were coalesced away while waiting for it. Returns false if
+// the client has been behind for too long.
+func (r *sendRate) wrote(now time.Time, writeTime time.Duration, coalesced int) bool {
+	if coalesced == 0 && writeTime <= slowStateWrite {
+		r.behindSince = time.Time{}
+		r.fastWrites++
+		if r.fastWrites >= fastWritesToSpeedUp && r.interval > 1 {
+			r.interval--
+			r.fastWrites = 0
+		}
+		return true
+	}
+
+	r.fastWrites = 0
+	if r.interval < maxStateInterval {
+		r.interval *= 2
+		if r.interval > maxStateInterval {
+			r.interval = maxStateInterval
+		}
+		return true
+	}
+
+	if r.behindSince.IsZero() {
+		r.behindSince = now
+	}
+	return now.Sub(r.behindSince) < slowClientTimeout
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSendRate(t *testing.T) {
+	now := time.Now()
+	r := newSendRate()
+
+	// falling behind backs off
+	assert.True(t, r.wrote(now, 0, 1))
+	assert.Equal(t, 2, r.interval)
+	assert.True(t, r.wrote(now, time.Second, 0))
+	assert.Equal(t, 4, r.interval)
+	assert.True(t, r.wrote(now, 0, 3))
+	assert.Equal(t, maxStateInterval, r.interval)
+
+	// keeping up speeds things back up
+	for i := 0; i < fastWritesToSpeedUp; i++ {
+		assert.True(t, r.wrote(now, 0, 0))
+	}
+	assert.Equal(t, maxStateInterval-1, r.interval)
+
+	// staying behind at the longest interval gets the client disconnected
+	r.interval = maxStateInterval
+	assert.True(t, r.wrote(now, 0, 1))
+	assert.True(t, r.wrote(now.Add(slowClientTimeout/2), 0, 1))
+	assert.False(t, r.wrote(now.Add(slowClientTimeout), 0, 1))
+}
+
+func TestSendLatest(t *testing.T) {
+	ws := &WebSocket{latestReady: make(chan struct{}, 1)}
+
+	msg, coalesced := ws.takeLatest()
+	assert.Nil(t, msg)
+
+	first := &WebSocketOutput{AssignedBodyId: "1"}
+	second := &WebSocketOutput{AssignedBodyId: "2"}
+	ws.SendLatest(first)
+	ws.SendLatest(second)
+	assert.Len(t, ws.latestReady, 1)
+
+	msg, coalesced = ws.takeLatest()
+	assert.Equal(t, second, msg)
+	assert.Equal(t, 1, coalesced)
+
+	msg, coalesced = ws.takeLatest()
+	assert.Nil(t, msg)
+	assert.Zero(t, coalesced)
+}
+
+func TestDisconnectSlowClient(t *testing.T) {
+	s := NewServer(logrus.StandardLogger())
+	defer s.Close()
+
+	client, err := newWebsocketConnection(s)
+	require.NoError(t, err)
+	defer client.Close()
+
+	var msg WebSocketOutput
+	require.NoError(t, client.ReadJSON(&msg))
+
+	room := s.defaultRoom
+	room.webSocketsMutex.Lock()
+	require.Len(t, room.webSockets, 1)
+	for ws := range room.webSockets {
+		before := SlowClientDisconnects()
+		ws.disconnectSlow("testing")
+		ws.disconnectSlow("testing")
+		assert.Equal(t, before+1, SlowClientDisconnects())
+	}
+	room.webSocketsMutex.Unlock()
+
+	for err == nil {
+		_, _, err = client.ReadMessage()
+	}
+}
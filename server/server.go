@@ -1,13 +1,14 @@
 package server
 
 import (
-	"io"
 	"net/http"
+	"sort"
 	"sync"
 	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
+	"github.com/pkg/errors"
 	"github.com/satori/go.uuid"
 	"github.com/sirupsen/logrus"
 
@@ -17,113 +18,352 @@ import (
 const tickDuration = time.Second / 30
 const threatSpawnInterval = time.Second * 5
 const foodSpawnInterval = time.Millisecond * 100
+const gravityOpeningAngle = 0.5
 
+// AbandonedBodyPolicy is what happens to a player's body once they've left.
+type AbandonedBodyPolicy string
+
+const (
+	// The body is removed from the universe.
+	RemoveAbandonedBodies AbandonedBodyPolicy = "remove"
+	// The body's thrust is cleared and it's left to drift.
+	DriftAbandonedBodies AbandonedBodyPolicy = "drift"
+)
+
+// Config is the configuration shared by every room.
+type Config struct {
+	// How long a player's body is left alone after their websocket closes.
+	// They can reconnect and take control of it again until this is up.
+	DisconnectGracePeriod time.Duration
+	// What happens to the body after the grace period.
+	AbandonedBodies AbandonedBodyPolicy
+	// The key session tokens are signed with. If it's empty, a random one is
+	// used, so sessions can't be resumed across restarts.
+	SessionSecret []byte
+	// How long a room can be empty before it's shut down.
+	EmptyRoomTimeout time.Duration
+	// The configuration of the default room, and of rooms created by players
+	// joining them by name.
+	DefaultRoom RoomConfig
+	// The configuration of the rooms quick play creates when the others are
+	// full.
+	QuickPlayRoom RoomConfig
+	// The most rooms that can be open at once, including the default room.
+	// Players can't create any more once there are this many.
+	MaxRooms int
+	// Words that players' names can't contain.
+	BannedNameWords []string
+	// If set, every chat message goes through this first.
+	ChatFilter ChatFilter
+	// The bearer token required by the admin API. If it's empty, the admin
+	// API is disabled.
+	AdminToken string
+	// /readyz fails if a room's ticks are this far behind schedule.
+	MaxTickLag time.Duration
+	// /readyz fails if any of a room's event queues are this full, from 0
+	// to 1.
+	MaxEventQueueFill float64
+}
+
+func DefaultConfig() Config {
+	return Config{
+		DisconnectGracePeriod: 10 * time.Second,
+		AbandonedBodies:       RemoveAbandonedBodies,
+		EmptyRoomTimeout:      time.Minute,
+		DefaultRoom:           DefaultRoomConfig(),
+		QuickPlayRoom:         defaultQuickPlayRoomConfig(),
+		MaxRooms:              100,
+		BannedNameWords:       defaultBannedNameWords,
+		MaxTickLag:            250 * time.Millisecond,
+		MaxEventQueueFill:     0.9,
+	}
+}
+
+// Validate returns an error if the configuration doesn't make sense.
+func (c Config) Validate() error {
+	if c.DisconnectGracePeriod < 0 {
+		return errors.New("disconnect grace period can't be negative")
+	}
+	if c.AbandonedBodies != RemoveAbandonedBodies && c.AbandonedBodies != DriftAbandonedBodies {
+		return errors.Errorf("unknown abandoned body policy %q", c.AbandonedBodies)
+	}
+	if c.EmptyRoomTimeout < 0 {
+		return errors.New("empty room timeout can't be negative")
+	}
+	if c.MaxTickLag <= 0 {
+		return errors.New("max tick lag must be positive")
+	}
+	if c.MaxRooms <= 0 {
+		return errors.New("max rooms must be positive")
+	}
+	if !(c.MaxEventQueueFill > 0 && c.MaxEventQueueFill <= 1) {
+		return errors.New("max event queue fill must be greater than 0 and at most 1")
+	}
+	if err := c.DefaultRoom.validate(); err != nil {
+		return errors.Wrap(err, "invalid default room")
+	}
+	return errors.Wrap(c.QuickPlayRoom.validate(), "invalid quick play room")
+}
+
+// The room clients join if they don't ask for one. It's always open, and it's
+// the one that's snapshotted and recorded.
+const DefaultRoomName = "default"
+
+// Server manages rooms and connects players to them.
 type Server struct {
-	logger          logrus.FieldLogger
-	universe        *game.Universe
-	router          *mux.Router
-	webSockets      map[*WebSocket]struct{}
-	webSocketsMutex sync.Mutex
-	stop            chan struct{}
-	stopped         chan struct{}
+	logger      logrus.FieldLogger
+	config      Config
+	router      *mux.Router
+	rooms       map[string]*Room
+	roomsMutex  sync.Mutex
+	defaultRoom *Room
+	// The number of rooms quick play has created, for naming them.
+	quickPlayRooms int
+	started        time.Time
+	// Closed when the server is closed. Once it is, no more rooms can be
+	// created.
+	closing   chan struct{}
+	closeOnce sync.Once
 }
 
+// DefaultUniverse creates a universe for the default room configuration.
 func DefaultUniverse() *game.Universe {
-	return game.NewUniverse(game.Rect{X: -5000, Y: -5000, W: 10000, H: 10000})
+	return DefaultRoomConfig().newUniverse()
 }
 
 func NewServer(logger logrus.FieldLogger) *Server {
+	return NewServerWithConfig(logger, DefaultConfig())
+}
+
+func NewServerWithConfig(logger logrus.FieldLogger, config Config) *Server {
+	return newServer(logger, config, config.DefaultRoom.newUniverse())
+}
+
+// NewServerFromSnapshot creates a server whose default room resumes the
+// universe captured by the snapshot.
+func NewServerFromSnapshot(logger logrus.FieldLogger, snapshot *game.Snapshot) (*Server, error) {
+	return NewServerFromSnapshotWithConfig(logger, DefaultConfig(), snapshot)
+}
+
+func NewServerFromSnapshotWithConfig(logger logrus.FieldLogger, config Config, snapshot *game.Snapshot) (*Server, error) {
+	universe := config.DefaultRoom.newUniverse()
+	if err := universe.Restore(snapshot); err != nil {
+		return nil, err
+	}
+	return newServer(logger, config, universe), nil
+}
+
+func newServer(logger logrus.FieldLogger, config Config, universe *game.Universe) *Server {
+	if len(config.SessionSecret) == 0 {
+		config.SessionSecret = newSessionSecret()
+	}
 	ret := &Server{
-		logger:     logger,
-		universe:   DefaultUniverse(),
-		router:     mux.NewRouter(),
-		webSockets: make(map[*WebSocket]struct{}),
-		stop:       make(chan struct{}),
-		stopped:    make(chan struct{}),
-	}
-	ret.router.HandleFunc("/", ret.indexHandler)
+		logger:  logger,
+		config:  config,
+		router:  mux.NewRouter(),
+		rooms:   make(map[string]*Room),
+		started: time.Now(),
+		closing: make(chan struct{}),
+	}
+	roomConfig := config.DefaultRoom
+	roomConfig.Bounds = universe.Bounds()
+	ret.defaultRoom = newRoom(logger, DefaultRoomName, config, roomConfig, universe, ret.closeIfEmpty)
+	ret.defaultRoom.template = defaultTemplate
+	ret.rooms[DefaultRoomName] = ret.defaultRoom
+
+	ret.router.HandleFunc("/", indexHandler)
 	ret.router.HandleFunc("/game", ret.gameHandler)
+	ret.router.HandleFunc("/game/{room}", ret.gameHandler)
+	ret.router.HandleFunc("/lobby", ret.lobbyHandler)
+	ret.router.HandleFunc("/lobby/quickplay", ret.quickPlayHandler)
+	ret.router.HandleFunc("/metrics", ret.metricsHandler)
+	ret.router.HandleFunc("/healthz", ret.healthHandler)
+	ret.router.HandleFunc("/readyz", ret.readyHandler)
+	ret.addAdminRoutes()
 	ret.router.NotFoundHandler = http.FileServer(http.Dir("dist"))
-	go ret.run()
 	return ret
 }
 
-func (s *Server) run() {
-	defer close(s.stopped)
-
-	tickTicker := time.NewTicker(tickDuration)
-	threatTicker := time.NewTicker(threatSpawnInterval)
-	foodTicker := time.NewTicker(foodSpawnInterval)
-	defer foodTicker.Stop()
-	defer threatTicker.Stop()
-	defer tickTicker.Stop()
-
-	for {
-		select {
-		case <-s.stop:
-			return
-		case <-threatTicker.C:
-			s.universe.AddEvent(game.ThreatSpawnEvent(s.universe))
-		case <-foodTicker.C:
-			s.universe.AddEvent(game.FoodSpawnEvent(s.universe))
-		case <-tickTicker.C:
-			s.tick()
-		}
+// CreateRoom creates a new room with its own universe.
+func (s *Server) CreateRoom(name string, config RoomConfig) error {
+	if !validRoomName(name) {
+		return errors.Errorf("invalid room name %q", name)
+	}
+	if err := config.validate(); err != nil {
+		return err
+	}
+
+	s.roomsMutex.Lock()
+	defer s.roomsMutex.Unlock()
+	if _, ok := s.rooms[name]; ok {
+		return errors.Errorf("room %q already exists", name)
 	}
+	_, err := s.createRoom(name, "", config)
+	return err
 }
 
-func (s *Server) tick() {
-	s.universe.Step(tickDuration)
+var (
+	errServerClosed = errors.New("server is closed")
+	errTooManyRooms = errors.New("too many rooms are open")
+)
 
-	var gameState WebSocketGameState
-	gameState.Universe.Bounds = s.universe.Bounds()
-	gameState.Universe.Bodies = make(map[string]*WebSocketBody)
-	for id, body := range s.universe.Bodies() {
-		gameState.Universe.Bodies[id.String()] = NewWebSocketBody(body)
+// Creates a room from the given config. If the config is one of the server's,
+// template says which so that the room is retuned along with it. The rooms
+// mutex must be locked.
+func (s *Server) createRoom(name, template string, config RoomConfig) (*Room, error) {
+	select {
+	case <-s.closing:
+		return nil, errServerClosed
+	default:
+	}
+	if len(s.rooms) >= s.config.MaxRooms {
+		return nil, errTooManyRooms
 	}
 
-	s.webSocketsMutex.Lock()
-	defer s.webSocketsMutex.Unlock()
+	ret := newRoom(s.logger, name, s.config, config, config.newUniverse(), s.closeIfEmpty)
+	ret.template = template
+	s.rooms[name] = ret
+	ret.logger.Info("created room")
+	return ret, nil
+}
 
-	for ws := range s.webSockets {
-		if !ws.IsAlive() {
-			delete(s.webSockets, ws)
-			continue
-		}
+// Rooms describes every open room, sorted by name.
+func (s *Server) Rooms() []RoomInfo {
+	s.roomsMutex.Lock()
+	rooms := make([]*Room, 0, len(s.rooms))
+	for _, room := range s.rooms {
+		rooms = append(rooms, room)
+	}
+	s.roomsMutex.Unlock()
 
-		ws.Send(&WebSocketOutput{
-			GameState: &gameState,
-		})
+	ret := make([]RoomInfo, len(rooms))
+	for i, room := range rooms {
+		ret[i] = room.Info()
 	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Name < ret[j].Name
+	})
+	return ret
 }
 
-// Close closes any hijacked connections.
-func (s *Server) Close() error {
-	close(s.stop)
-	<-s.stopped
+// DestroyRoom shuts down a room, kicking out everyone in it. The default room
+// can't be destroyed.
+func (s *Server) DestroyRoom(name string) error {
+	if name == DefaultRoomName {
+		return errors.New("the default room can't be destroyed")
+	}
+
+	s.roomsMutex.Lock()
+	room, ok := s.rooms[name]
+	delete(s.rooms, name)
+	s.roomsMutex.Unlock()
 
-	var closers []io.Closer
+	if !ok {
+		return errors.Errorf("room %q doesn't exist", name)
+	}
+	// the room's goroutine might be waiting on the mutex, so this has to happen
+	// after it's unlocked
+	return room.Close()
+}
 
-	s.webSocketsMutex.Lock()
-	for ws := range s.webSockets {
-		closers = append(closers, ws)
+// Called by rooms that have been empty for a while. Returns true if the room
+// should shut down.
+func (s *Server) closeIfEmpty(room *Room) bool {
+	if room == s.defaultRoom {
+		return false
 	}
-	s.webSocketsMutex.Unlock()
 
-	for _, closer := range closers {
-		closer.Close()
+	s.roomsMutex.Lock()
+	defer s.roomsMutex.Unlock()
+
+	// someone may have connected in the meantime
+	if s.rooms[room.name] != room || !room.isEmpty() {
+		return false
+	}
+	delete(s.rooms, room.name)
+	return true
+}
+
+// Close shuts down every room.
+func (s *Server) Close() error {
+	s.roomsMutex.Lock()
+	s.closeOnce.Do(func() {
+		close(s.closing)
+	})
+	rooms := s.rooms
+	s.rooms = make(map[string]*Room)
+	s.roomsMutex.Unlock()
+
+	for _, room := range rooms {
+		room.Close()
 	}
 	return nil
 }
 
+// Snapshot captures the state of the default room's universe. The server must
+// be closed first.
+func (s *Server) Snapshot() *game.Snapshot {
+	return s.defaultRoom.Snapshot()
+}
+
+// StartRecording starts recording everything that happens in the default room
+// so that it can be replayed later.
+func (s *Server) StartRecording() {
+	s.defaultRoom.StartRecording()
+}
+
+// StopRecording returns everything recorded since StartRecording was called.
+// The server must be closed first.
+func (s *Server) StopRecording() *game.Recording {
+	return s.defaultRoom.StopRecording()
+}
+
 var upgrader = websocket.Upgrader{
 	CheckOrigin: func(r *http.Request) bool {
 		return true
 	},
 	EnableCompression: true,
+	Subprotocols:      []string{BinarySubprotocol, JSONSubprotocol},
 }
 
+// Players choose a room with either the path or the room query parameter.
+// Rooms that don't exist yet are created with the default configuration. If
+// the spectate query parameter is set, they join as a spectator instead.
+// Players can pick their name, color, and team with the name, color, and team
+// parameters.
 func (s *Server) gameHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["room"]
+	if name == "" {
+		name = r.URL.Query().Get("room")
+	}
+	if name == "" {
+		name = DefaultRoomName
+	}
+	if !validRoomName(name) {
+		http.Error(w, "invalid room name", http.StatusBadRequest)
+		return
+	}
+
+	spectate := r.URL.Query().Get("spectate") != ""
+	s.connectWebSocket(w, r, spectate, func() (*Room, error) {
+		if room, ok := s.rooms[name]; ok {
+			return room, nil
+		}
+		return s.createRoom(name, defaultTemplate, s.config.DefaultRoom)
+	})
+}
+
+// Upgrades the request to a websocket and connects it to the room returned by
+// pickRoom, which is called with the rooms mutex locked. If pickRoom fails,
+// the websocket is closed with its error as the reason.
+func (s *Server) connectWebSocket(w http.ResponseWriter, r *http.Request, spectate bool, pickRoom func() (*Room, error)) {
+	query := r.URL.Query()
+	identity, err := newIdentity(query.Get("name"), query.Get("color"), query.Get("team"), s.config.BannedNameWords)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		s.logger.Warn(err)
@@ -134,14 +374,33 @@ func (s *Server) gameHandler(w http.ResponseWriter, r *http.Request) {
 	logger := s.logger.WithField("connection_id", uuid.NewV4())
 	logger.Info("accepted websocket connection")
 
-	ws := NewWebSocket(logger, conn, s.universe)
+	var sessionId string
+	if token := r.URL.Query().Get("session"); token != "" {
+		var ok bool
+		if sessionId, ok = verifySessionToken(s.config.SessionSecret, token); !ok {
+			logger.Warn("invalid session token")
+		}
+	}
 
-	s.webSocketsMutex.Lock()
-	defer s.webSocketsMutex.Unlock()
-	s.webSockets[ws] = struct{}{}
+	// the mutex is held while connecting so that the room can't shut down
+	// before the player is in it
+	s.roomsMutex.Lock()
+	defer s.roomsMutex.Unlock()
+	room, err := pickRoom()
+	if err != nil {
+		logger.WithError(err).Warn("unable to pick a room")
+		conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseTryAgainLater, err.Error()), time.Now().Add(time.Second))
+		conn.Close()
+		return
+	}
+	if spectate {
+		room.connectSpectator(logger, conn)
+	} else {
+		room.connect(logger, conn, sessionId, identity)
+	}
 }
 
-func (s *Server) indexHandler(w http.ResponseWriter, r *http.Request) {
+func indexHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "text/html")
 	http.ServeFile(w, r, "dist/index.html")
 }
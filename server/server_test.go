@@ -3,6 +3,9 @@ package server
 import (
 	"net"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
 	"time"
 
@@ -10,9 +13,15 @@ import (
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+
+	"github.com/vmrob/grav-game/game"
 )
 
-func newWebsocketConnection(server *Server) (*websocket.Conn, error) {
+func newWebsocketConnection(server http.Handler, subprotocols ...string) (*websocket.Conn, error) {
+	return dialWebsocket(server, "/game", subprotocols...)
+}
+
+func dialWebsocket(server http.Handler, path string, subprotocols ...string) (*websocket.Conn, error) {
 	l, err := net.Listen("tcp", "127.0.0.1:0")
 	if err != nil {
 		return nil, err
@@ -30,7 +39,10 @@ func newWebsocketConnection(server *Server) (*websocket.Conn, error) {
 	var client *websocket.Conn
 	var firstError error
 	for attempts := 0; true; attempts++ {
-		client, _, err = websocket.DefaultDialer.Dial("ws://"+l.Addr().String()+"/game", http.Header{})
+		dialer := websocket.Dialer{
+			Subprotocols: subprotocols,
+		}
+		client, _, err = dialer.Dial("ws://"+l.Addr().String()+path, http.Header{})
 		if err == nil {
 			return client, nil
 		} else if firstError == nil {
@@ -60,3 +72,242 @@ func TestServer(t *testing.T) {
 	}
 	assert.NotEmpty(t, assignedBodyId)
 }
+
+func TestServerDisconnect(t *testing.T) {
+	config := DefaultConfig()
+	config.DisconnectGracePeriod = 200 * time.Millisecond
+	s := NewServerWithConfig(logrus.StandardLogger(), config)
+
+	leaving, err := newWebsocketConnection(s)
+	require.NoError(t, err)
+	staying, err := newWebsocketConnection(s)
+	require.NoError(t, err)
+	defer staying.Close()
+
+	var msg WebSocketOutput
+	for msg.AssignedBodyId == "" {
+		require.NoError(t, leaving.ReadJSON(&msg))
+	}
+	bodyId := msg.AssignedBodyId
+	leaving.Close()
+	disconnected := time.Now()
+
+	var playerLeft *WebSocketPlayerLeft
+	for i := 0; playerLeft == nil && i < 100; i++ {
+		msg = WebSocketOutput{}
+		require.NoError(t, staying.ReadJSON(&msg))
+		playerLeft = msg.PlayerLeft
+	}
+	require.NotNil(t, playerLeft)
+	assert.Equal(t, bodyId, playerLeft.BodyId)
+	assert.True(t, time.Since(disconnected) >= config.DisconnectGracePeriod)
+
+	s.Close()
+	for id := range s.defaultRoom.universe.Bodies() {
+		assert.NotEqual(t, bodyId, id.String())
+	}
+}
+
+func TestServerResume(t *testing.T) {
+	s := NewServer(logrus.StandardLogger())
+	defer s.Close()
+
+	join := func(path string) (*websocket.Conn, *WebSocketOutput) {
+		client, err := dialWebsocket(s, path)
+		require.NoError(t, err)
+		for {
+			var msg WebSocketOutput
+			require.NoError(t, client.ReadJSON(&msg))
+			if msg.AssignedBodyId != "" {
+				return client, &msg
+			}
+		}
+	}
+
+	client, first := join("/game")
+	require.NotEmpty(t, first.SessionToken)
+	client.Close()
+
+	client, resumed := join("/game?session=" + url.QueryEscape(first.SessionToken))
+	defer client.Close()
+	assert.Equal(t, first.AssignedBodyId, resumed.AssignedBodyId)
+
+	forged := strings.Replace(first.SessionToken, ".", ".x", 1)
+	other, msg := join("/game?session=" + url.QueryEscape(forged))
+	defer other.Close()
+	assert.NotEqual(t, first.AssignedBodyId, msg.AssignedBodyId)
+}
+
+func TestServerResumeOpenSession(t *testing.T) {
+	s := NewServer(logrus.StandardLogger())
+	defer s.Close()
+
+	join := func(path string) (*websocket.Conn, *WebSocketOutput) {
+		client, err := dialWebsocket(s, path)
+		require.NoError(t, err)
+		for {
+			var msg WebSocketOutput
+			require.NoError(t, client.ReadJSON(&msg))
+			if msg.AssignedBodyId != "" {
+				return client, &msg
+			}
+		}
+	}
+
+	old, first := join("/game")
+	defer old.Close()
+	client, resumed := join("/game?session=" + url.QueryEscape(first.SessionToken))
+	defer client.Close()
+	assert.Equal(t, first.AssignedBodyId, resumed.AssignedBodyId)
+
+	// the old connection is closed rather than left controlling the body
+	old.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var err error
+	for err == nil {
+		var msg WebSocketOutput
+		err = old.ReadJSON(&msg)
+	}
+	if netErr, ok := err.(net.Error); ok {
+		assert.False(t, netErr.Timeout(), "the old connection is still open")
+	}
+
+	s.defaultRoom.webSocketsMutex.Lock()
+	assert.Equal(t, 1, len(s.defaultRoom.webSockets))
+	s.defaultRoom.webSocketsMutex.Unlock()
+}
+
+func TestServerSnapshot(t *testing.T) {
+	s := NewServer(logrus.StandardLogger())
+	time.Sleep(tickDuration * 5)
+	s.Close()
+
+	snapshot := s.Snapshot()
+	assert.NotZero(t, snapshot.Tick)
+
+	resumed, err := NewServerFromSnapshot(logrus.StandardLogger(), snapshot)
+	require.NoError(t, err)
+	defer resumed.Close()
+	assert.Equal(t, snapshot.Bounds, resumed.defaultRoom.universe.Bounds())
+}
+
+func TestServerSnapshotKeepsPlayers(t *testing.T) {
+	s := NewServer(logrus.StandardLogger())
+	s.StartRecording()
+
+	client, err := newWebsocketConnection(s)
+	require.NoError(t, err)
+	defer client.Close()
+	var msg WebSocketOutput
+	for msg.AssignedBodyId == "" {
+		require.NoError(t, client.ReadJSON(&msg))
+	}
+	s.Close()
+
+	found := false
+	for _, b := range s.Snapshot().Bodies {
+		found = found || b.Id.String() == msg.AssignedBodyId
+	}
+	assert.True(t, found, "the player's body isn't in the snapshot")
+	for _, in := range s.StopRecording().Inputs {
+		assert.NotEqual(t, game.RemoveBodyInput, in.Kind)
+	}
+}
+
+func TestRooms(t *testing.T) {
+	config := DefaultConfig()
+	config.DisconnectGracePeriod = 0
+	config.EmptyRoomTimeout = 200 * time.Millisecond
+	s := NewServerWithConfig(logrus.StandardLogger(), config)
+	defer s.Close()
+
+	roomConfig := DefaultRoomConfig()
+	roomConfig.Bounds = game.Rect{X: 0, Y: 0, W: 1000, H: 1000}
+	require.NoError(t, s.CreateRoom("small", roomConfig))
+	assert.Error(t, s.CreateRoom("small", roomConfig))
+	assert.Error(t, s.CreateRoom("not a valid name", roomConfig))
+	roomConfig.TickDuration = 0
+	assert.Error(t, s.CreateRoom("invalid", roomConfig))
+
+	join := func(path string) (*websocket.Conn, *WebSocketOutput) {
+		client, err := dialWebsocket(s, path)
+		require.NoError(t, err)
+		var msg WebSocketOutput
+		for msg.GameState == nil {
+			msg = WebSocketOutput{}
+			require.NoError(t, client.ReadJSON(&msg))
+		}
+		return client, &msg
+	}
+
+	small, msg := join("/game/small")
+	assert.Equal(t, game.Rect{X: 0, Y: 0, W: 1000, H: 1000}, msg.GameState.Universe.Bounds)
+	other, msg := join("/game?room=other")
+	assert.Equal(t, DefaultRoomConfig().Bounds, msg.GameState.Universe.Bounds)
+
+	waitForPlayers := func(name string, players int) {
+		for i := 0; i < 100; i++ {
+			for _, info := range s.Rooms() {
+				if info.Name == name && info.Players == players {
+					return
+				}
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("room %v never had %v players", name, players)
+	}
+	waitForPlayers("small", 1)
+	waitForPlayers("other", 1)
+
+	var names []string
+	for _, info := range s.Rooms() {
+		names = append(names, info.Name)
+	}
+	assert.Equal(t, []string{"default", "other", "small"}, names)
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/game?room=not+valid", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	assert.Error(t, s.DestroyRoom(DefaultRoomName))
+	require.NoError(t, s.DestroyRoom("other"))
+	assert.Error(t, s.DestroyRoom("other"))
+	other.Close()
+
+	// empty rooms shut down on their own, except for the default one
+	small.Close()
+	for i := 0; i < 100 && len(s.Rooms()) > 1; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	rooms := s.Rooms()
+	require.Len(t, rooms, 1)
+	assert.Equal(t, DefaultRoomName, rooms[0].Name)
+}
+
+func TestMaxRooms(t *testing.T) {
+	config := DefaultConfig()
+	config.MaxRooms = 2
+	s := NewServerWithConfig(logrus.StandardLogger(), config)
+	defer s.Close()
+
+	require.NoError(t, s.CreateRoom("a", DefaultRoomConfig()))
+	assert.Error(t, s.CreateRoom("b", DefaultRoomConfig()))
+
+	refused := func(path string) {
+		client, err := dialWebsocket(s, path)
+		require.NoError(t, err)
+		defer client.Close()
+		var msg WebSocketOutput
+		for err == nil {
+			err = client.ReadJSON(&msg)
+		}
+		assert.True(t, websocket.IsCloseError(err, websocket.CloseTryAgainLater), "%v", err)
+	}
+	refused("/game/b")
+	assert.Len(t, s.Rooms(), 2)
+
+	// nothing can create rooms once the server is closed
+	require.NoError(t, s.Close())
+	refused("/game")
+	assert.Empty(t, s.Rooms())
+	assert.Error(t, s.CreateRoom("c", DefaultRoomConfig()))
+}
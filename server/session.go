@@ -0,0 +1,89 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+	"time"
+
+	"github.com/satori/go.uuid"
+
+	"github.com/vmrob/grav-game/game"
+)
+
+// session is a player's claim on a body in the universe. It outlives the
+// player's websocket so that the body can be cleaned up after they leave.
+//
+// Sessions should only be used from the goroutine that steps the universe.
+type session struct {
+	id     string
+	bodyId game.BodyId
+	body   *game.Body
+	// The player's display name, color, and team, which may be empty.
+	name  string
+	color string
+	team  string
+	// Limits how quickly the player can chat.
+	chatLimiter rateLimiter
+	// The player's current websocket, or nil if they're disconnected.
+	ws *WebSocket
+	// When the player's websocket closed.
+	disconnectedAt time.Time
+}
+
+func newSession() *session {
+	return &session{
+		id: uuid.NewV4().String(),
+		body: &game.Body{
+			Mass: game.PlayerStartMass,
+		},
+	}
+}
+
+// addBody adds the session's body to the universe at a random point.
+func (s *session) addBody(universe *game.Universe) {
+	s.bodyId = universe.AddBodyAtRandomPosition(s.body)
+}
+
+// hasBody returns true if the session's body is in the universe. It isn't
+// until the body has been added, and it's gone once the body is absorbed.
+func (s *session) hasBody(universe *game.Universe) bool {
+	return universe.GetBody(s.bodyId) == s.body
+}
+
+// Session tokens are the session id followed by an HMAC of it, which keeps
+// players from taking over each other's sessions by guessing ids.
+func signSessionToken(secret []byte, id string) string {
+	return id + "." + base64.RawURLEncoding.EncodeToString(sessionMAC(secret, id))
+}
+
+// verifySessionToken returns the session id in the token, or false if the
+// token wasn't signed with the secret.
+func verifySessionToken(secret []byte, token string) (string, bool) {
+	i := strings.LastIndexByte(token, '.')
+	if i < 0 {
+		return "", false
+	}
+	id := token[:i]
+	mac, err := base64.RawURLEncoding.DecodeString(token[i+1:])
+	if err != nil || !hmac.Equal(mac, sessionMAC(secret, id)) {
+		return "", false
+	}
+	return id, true
+}
+
+func sessionMAC(secret []byte, id string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(id))
+	return mac.Sum(nil)
+}
+
+func newSessionSecret() []byte {
+	ret := make([]byte, 32)
+	if _, err := rand.Read(ret); err != nil {
+		panic(err)
+	}
+	return ret
+}
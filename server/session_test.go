@@ -0,0 +1,23 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSessionToken(t *testing.T) {
+	secret := newSessionSecret()
+	token := signSessionToken(secret, "abc")
+
+	id, ok := verifySessionToken(secret, token)
+	assert.True(t, ok)
+	assert.Equal(t, "abc", id)
+
+	_, ok = verifySessionToken(newSessionSecret(), token)
+	assert.False(t, ok)
+	_, ok = verifySessionToken(secret, "abd"+token[3:])
+	assert.False(t, ok)
+	_, ok = verifySessionToken(secret, "abc")
+	assert.False(t, ok)
+}
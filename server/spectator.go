@@ -0,0 +1,111 @@
+package server
+
+import (
+	"sort"
+
+	"github.com/vmrob/grav-game/game"
+)
+
+// spectator is what a spectating websocket is following. Spectators follow
+// either a specific body or whichever body has a rank, which is the body's
+// place when bodies are ordered from heaviest to lightest.
+//
+// Spectators should only be used from the goroutine that steps the universe.
+type spectator struct {
+	// The body the spectator asked to follow. If it's empty, they follow the
+	// body at rank instead.
+	bodyId string
+	rank   int
+	// What's actually being followed as of the last tick.
+	following     string
+	followingRank int
+}
+
+// Spectators start out following the heaviest body.
+func newSpectator() *spectator {
+	return &spectator{
+		rank: 1,
+	}
+}
+
+// follow changes what the spectator is following. Names are resolved to
+// bodies with findByName right away, so the spectator keeps following the same
+// body if its name is reused later. Returns false if there's no body with the
+// name.
+func (s *spectator) follow(control *WebSocketSpectateControl, findByName func(name string) (game.BodyId, bool)) bool {
+	switch {
+	case control.BodyId != "":
+		s.bodyId = control.BodyId
+	case control.Name != "":
+		id, ok := findByName(control.Name)
+		if !ok {
+			return false
+		}
+		s.bodyId = id.String()
+	case control.Rank > 0:
+		s.bodyId = ""
+		s.rank = control.Rank
+	}
+	return true
+}
+
+// update works out which body to follow this tick. rankings is only called
+// if it's needed. Returns the spectator's new state if it's changed.
+func (s *spectator) update(bodies map[string]*WebSocketBody, rankings func() []string) *WebSocketSpectatorState {
+	if s.bodyId != "" && bodies[s.bodyId] == nil {
+		// the body is gone, so go back to following the leader
+		s.bodyId = ""
+		s.rank = 1
+	}
+
+	following, rank := s.bodyId, 0
+	if following == "" {
+		ranked := rankings()
+		if len(ranked) > 0 {
+			rank = s.rank
+			if rank > len(ranked) {
+				rank = len(ranked)
+			}
+			following = ranked[rank-1]
+		}
+	}
+
+	if following == s.following && rank == s.followingRank {
+		return nil
+	}
+	s.following, s.followingRank = following, rank
+	return &WebSocketSpectatorState{
+		BodyId: following,
+		Rank:   rank,
+	}
+}
+
+// rankBodies returns the ids of the bodies from heaviest to lightest.
+func rankBodies(bodies map[string]*WebSocketBody) []string {
+	ret := make([]string, 0, len(bodies))
+	for id := range bodies {
+		ret = append(ret, id)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		a, b := bodies[ret[i]], bodies[ret[j]]
+		if a.Mass != b.Mass {
+			return a.Mass > b.Mass
+		}
+		return ret[i] < ret[j]
+	})
+	return ret
+}
+
+// Returns the body with the given major or minor name. If there's more than
+// one, the oldest wins. Player names are handled by the room.
+func findBodyByName(universe *game.Universe, name string) (game.BodyId, bool) {
+	var ret game.BodyId
+	found := false
+	for id, body := range universe.Bodies() {
+		if (body.MajorName == name || body.MinorName == name) && (!found || id < ret) {
+			ret = id
+			found = true
+		}
+	}
+	return ret, found
+}
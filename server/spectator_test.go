@@ -0,0 +1,88 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/vmrob/grav-game/game"
+)
+
+func TestSpectator(t *testing.T) {
+	universe := DefaultUniverse()
+	heavy := universe.AddBody(&game.Body{Mass: 100, MajorName: "Aldebaran"})
+	light := universe.AddBody(&game.Body{Mass: 10})
+	bodies := NewWebSocketBodies(universe)
+	assert.Equal(t, []string{heavy.String(), light.String()}, rankBodies(bodies))
+
+	rank := func() []string {
+		return rankBodies(bodies)
+	}
+
+	find := func(name string) (game.BodyId, bool) {
+		return findBodyByName(universe, name)
+	}
+
+	s := newSpectator()
+	assert.Equal(t, &WebSocketSpectatorState{BodyId: heavy.String(), Rank: 1}, s.update(bodies, rank))
+	assert.Nil(t, s.update(bodies, rank))
+
+	// ranks past the end follow the lightest body
+	assert.True(t, s.follow(&WebSocketSpectateControl{Rank: 5}, find))
+	assert.Equal(t, &WebSocketSpectatorState{BodyId: light.String(), Rank: 2}, s.update(bodies, rank))
+
+	assert.True(t, s.follow(&WebSocketSpectateControl{Name: "Aldebaran"}, find))
+	assert.Equal(t, &WebSocketSpectatorState{BodyId: heavy.String()}, s.update(bodies, rank))
+	assert.False(t, s.follow(&WebSocketSpectateControl{Name: "Betelgeuse"}, find))
+	assert.Nil(t, s.update(bodies, rank))
+
+	// once the body is gone, the spectator goes back to following the leader
+	assert.True(t, s.follow(&WebSocketSpectateControl{BodyId: light.String()}, find))
+	assert.Equal(t, &WebSocketSpectatorState{BodyId: light.String()}, s.update(bodies, rank))
+	delete(bodies, light.String())
+	assert.Equal(t, &WebSocketSpectatorState{BodyId: heavy.String(), Rank: 1}, s.update(bodies, rank))
+}
+
+func TestServerSpectator(t *testing.T) {
+	s := NewServer(logrus.StandardLogger())
+	defer s.Close()
+
+	client, err := dialWebsocket(s, "/game?spectate=1")
+	require.NoError(t, err)
+	defer client.Close()
+
+	var spectating *WebSocketSpectatorState
+	gameStates := 0
+	for i := 0; (spectating == nil || gameStates == 0) && i < 30; i++ {
+		var msg WebSocketOutput
+		require.NoError(t, client.ReadJSON(&msg))
+		assert.Empty(t, msg.AssignedBodyId)
+		if msg.Spectating != nil {
+			spectating = msg.Spectating
+		}
+		if msg.GameState != nil {
+			gameStates++
+		}
+	}
+	require.NotNil(t, spectating)
+	assert.Equal(t, 1, spectating.Rank)
+	assert.NotZero(t, gameStates)
+
+	rooms := s.Rooms()
+	require.Len(t, rooms, 1)
+	assert.Equal(t, 0, rooms[0].Players)
+	assert.Equal(t, 1, rooms[0].Spectators)
+
+	require.NoError(t, client.WriteJSON(&WebSocketInput{
+		Spectate: &WebSocketSpectateControl{Rank: 2},
+	}))
+	for spectating.Rank != 2 {
+		var msg WebSocketOutput
+		require.NoError(t, client.ReadJSON(&msg))
+		if msg.Spectating != nil {
+			spectating = msg.Spectating
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/vmrob/grav-game/game"
+)
+
+// Tuning is the part of a room's configuration that can be changed while the
+// room is running.
+type Tuning struct {
+	ThreatSpawnInterval time.Duration
+	FoodSpawnInterval   time.Duration
+	Physics             game.Physics
+}
+
+func (t Tuning) validate() error {
+	if t.ThreatSpawnInterval < 0 || t.FoodSpawnInterval < 0 {
+		return errors.New("spawn intervals can't be negative")
+	}
+	return errors.Wrap(t.Physics.Validate(), "invalid physics")
+}
+
+// Tuning returns the config's tuning.
+func (c RoomConfig) Tuning() Tuning {
+	return Tuning{
+		ThreatSpawnInterval: c.ThreatSpawnInterval,
+		FoodSpawnInterval:   c.FoodSpawnInterval,
+		Physics:             c.Physics,
+	}
+}
+
+func (c *RoomConfig) setTuning(t Tuning) {
+	c.ThreatSpawnInterval = t.ThreatSpawnInterval
+	c.FoodSpawnInterval = t.FoodSpawnInterval
+	c.Physics = t.Physics
+}
+
+// Describes each field that differs between two tunings as "old -> new".
+func tuningChanges(old, new Tuning) logrus.Fields {
+	ret := logrus.Fields{}
+	addChangedFields(ret, "", reflect.ValueOf(old), reflect.ValueOf(new))
+	return ret
+}
+
+func addChangedFields(fields logrus.Fields, prefix string, old, new reflect.Value) {
+	for i := 0; i < old.NumField(); i++ {
+		name := prefix + old.Type().Field(i).Name
+		a, b := old.Field(i), new.Field(i)
+		if a.Kind() == reflect.Struct {
+			addChangedFields(fields, name+".", a, b)
+		} else if a.Interface() != b.Interface() {
+			fields[name] = fmt.Sprintf("%v -> %v", a.Interface(), b.Interface())
+		}
+	}
+}
+
+// Rooms created from one of the server's room configurations are retuned
+// along with it.
+const (
+	defaultTemplate   = "default"
+	quickPlayTemplate = "quickplay"
+)
+
+// Retune changes the tuning of the server's default and quick play room
+// configurations to match the given config, along with every open room that
+// was created from them. Rooms created with CreateRoom are left alone, and so
+// is everything in the config that isn't tuning.
+func (s *Server) Retune(config Config) error {
+	tunings := map[string]Tuning{
+		defaultTemplate:   config.DefaultRoom.Tuning(),
+		quickPlayTemplate: config.QuickPlayRoom.Tuning(),
+	}
+	for template, tuning := range tunings {
+		if err := tuning.validate(); err != nil {
+			return errors.Wrapf(err, "invalid %v room tuning", template)
+		}
+	}
+
+	type retune struct {
+		room   *Room
+		tuning Tuning
+	}
+	var retunes []retune
+
+	s.roomsMutex.Lock()
+	s.config.DefaultRoom.setTuning(tunings[defaultTemplate])
+	s.config.QuickPlayRoom.setTuning(tunings[quickPlayTemplate])
+	for _, room := range s.rooms {
+		if tuning, ok := tunings[room.template]; ok {
+			retunes = append(retunes, retune{room, tuning})
+		}
+	}
+	s.roomsMutex.Unlock()
+
+	// rooms lock the rooms mutex when they shut down, so they can't be
+	// retuned while it's held
+	for _, r := range retunes {
+		if err := r.room.Retune(r.tuning); err != nil && err != errRoomClosed {
+			return err
+		}
+	}
+	return nil
+}
+
+// WatchConfigFile checks the config file at path for changes every interval
+// until the server is closed. Whenever it changes, it's loaded the same way
+// it is at startup, by load, and the server is retuned to match. load should
+// apply the same environment variables and flags, so they keep overriding the
+// file. Invalid configs are logged and otherwise ignored.
+func (s *Server) WatchConfigFile(path string, load func() (FileConfig, error), interval time.Duration) {
+	logger := s.logger.WithField("path", path)
+	modified := func() (time.Time, int64) {
+		info, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, 0
+		}
+		return info.ModTime(), info.Size()
+	}
+	lastModTime, lastSize := modified()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.closing:
+				return
+			case <-ticker.C:
+			}
+
+			modTime, size := modified()
+			if modTime.Equal(lastModTime) && size == lastSize {
+				continue
+			}
+			lastModTime, lastSize = modTime, size
+
+			if err := s.reloadConfigFile(load); err != nil {
+				logger.WithError(err).Error("ignoring config file changes")
+				continue
+			}
+			logger.Info("reloaded config file")
+		}
+	}()
+}
+
+func (s *Server) reloadConfigFile(load func() (FileConfig, error)) error {
+	fileConfig, err := load()
+	if err != nil {
+		return err
+	}
+	config, err := fileConfig.Config()
+	if err != nil {
+		return errors.Wrap(err, "invalid configuration")
+	}
+	return s.Retune(config)
+}
+
+type retuneRequest struct {
+	tuning Tuning
+	done   chan struct{}
+}
+
+// Tuning returns the room's current tuning.
+func (r *Room) Tuning() Tuning {
+	r.webSocketsMutex.Lock()
+	defer r.webSocketsMutex.Unlock()
+	return r.roomConfig.Tuning()
+}
+
+// Retune changes the room's tuning. The change is applied between ticks, and
+// Retune waits for it to be.
+func (r *Room) Retune(t Tuning) error {
+	if err := t.validate(); err != nil {
+		return err
+	}
+	req := retuneRequest{
+		tuning: t,
+		done:   make(chan struct{}),
+	}
+	select {
+	case r.retune <- req:
+	case <-r.stopped:
+		return errRoomClosed
+	}
+	<-req.done
+	return nil
+}
+
+// This is called by the run goroutine. It returns the tuning that was
+// replaced.
+func (r *Room) applyTuning(t Tuning) Tuning {
+	r.webSocketsMutex.Lock()
+	old := r.roomConfig.Tuning()
+	r.roomConfig.setTuning(t)
+	r.webSocketsMutex.Unlock()
+
+	changes := tuningChanges(old, t)
+	if len(changes) == 0 {
+		return old
+	}
+	if t.Physics != old.Physics {
+		r.universe.SetPhysics(t.Physics)
+	}
+	r.logger.WithFields(changes).Info("retuned room")
+	return old
+}
@@ -0,0 +1,130 @@
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTuningChanges(t *testing.T) {
+	old := DefaultRoomConfig().Tuning()
+	assert.Empty(t, tuningChanges(old, old))
+
+	tuning := old
+	tuning.FoodSpawnInterval = time.Minute
+	tuning.Physics.DecayPerStep = 0.5
+	assert.Equal(t, logrus.Fields{
+		"FoodSpawnInterval":    old.FoodSpawnInterval.String() + " -> 1m0s",
+		"Physics.DecayPerStep": "0.0001 -> 0.5",
+	}, tuningChanges(old, tuning))
+}
+
+func TestRetune(t *testing.T) {
+	s := NewServer(logrus.StandardLogger())
+	defer s.Close()
+	require.NoError(t, s.CreateRoom("custom", DefaultRoomConfig()))
+
+	config := DefaultConfig()
+	config.DefaultRoom.ThreatSpawnInterval = 0
+	config.DefaultRoom.Physics.ThrustMagnitude *= 2
+	config.QuickPlayRoom.Physics.FoodMaxMass *= 2
+	require.NoError(t, s.Retune(config))
+
+	assert.Equal(t, config.DefaultRoom.Tuning(), s.defaultRoom.Tuning())
+	assert.Equal(t, config.DefaultRoom.Physics, s.defaultRoom.universe.Physics())
+	assert.Equal(t, config.QuickPlayRoom.Tuning(), s.config.QuickPlayRoom.Tuning())
+	s.roomsMutex.Lock()
+	custom := s.rooms["custom"]
+	s.roomsMutex.Unlock()
+	assert.Equal(t, DefaultRoomConfig().Tuning(), custom.Tuning())
+
+	config.DefaultRoom.Physics.DecayPerStep = 2
+	assert.Error(t, s.Retune(config))
+	assert.Error(t, s.defaultRoom.Retune(config.DefaultRoom.Tuning()))
+}
+
+func TestAdminTuning(t *testing.T) {
+	config := DefaultConfig()
+	config.AdminToken = "secret"
+	s := NewServerWithConfig(logrus.StandardLogger(), config)
+	defer s.Close()
+
+	request := func(method, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/admin/rooms/default/tuning", strings.NewReader(body))
+		r.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		return w
+	}
+
+	w := request("GET", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var tuning FileTuning
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&tuning))
+	assert.Equal(t, newFileTuning(config.DefaultRoom.Tuning()), tuning)
+
+	w = request("PUT", `{"FoodSpawnInterval": "10s", "Physics": {"DecayPerStep": 0.01}}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&tuning))
+	assert.Equal(t, Duration(10*time.Second), tuning.FoodSpawnInterval)
+	assert.Equal(t, 0.01, tuning.Physics.DecayPerStep)
+	// everything else is left alone
+	assert.Equal(t, Duration(config.DefaultRoom.ThreatSpawnInterval), tuning.ThreatSpawnInterval)
+	assert.Equal(t, config.DefaultRoom.Physics.ThrustMagnitude, tuning.Physics.ThrustMagnitude)
+	assert.Equal(t, 0.01, s.defaultRoom.universe.Physics().DecayPerStep)
+
+	assert.Equal(t, http.StatusBadRequest, request("PUT", `{"FoodSpawnInterval": "-1s"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, request("PUT", `{"Bounds": {}}`).Code)
+	assert.Equal(t, 0.01, s.defaultRoom.Tuning().Physics.DecayPerStep)
+}
+
+func TestWatchConfigFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grav-config")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.json")
+
+	s := NewServer(logrus.StandardLogger())
+	defer s.Close()
+	s.WatchConfigFile(path, func() (FileConfig, error) { return LoadFileConfig(path) }, 10*time.Millisecond)
+
+	// file changes are noticed by their modification time and size, so each
+	// write is given a different time
+	modTime := time.Now()
+	write := func(contents string) {
+		require.NoError(t, ioutil.WriteFile(path, []byte(contents), 0644))
+		modTime = modTime.Add(time.Second)
+		require.NoError(t, os.Chtimes(path, modTime, modTime))
+	}
+	waitForThrust := func(thrust float64) bool {
+		for i := 0; i < 100; i++ {
+			if s.defaultRoom.Tuning().Physics.ThrustMagnitude == thrust {
+				return true
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		return false
+	}
+
+	write(`{"DefaultRoom": {"Physics": {"ThrustMagnitude": 123}}}`)
+	assert.True(t, waitForThrust(123))
+
+	// invalid configs are ignored
+	write(`{"DefaultRoom": {"Physics": {"ThrustMagnitude": -1}}}`)
+	write(`{"DefaultRoom": {"Physics": {"ThrustMagnitude": 456}, "Oops": 1}}`)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 123.0, s.defaultRoom.Tuning().Physics.ThrustMagnitude)
+
+	write(`{"DefaultRoom": {"Physics": {"ThrustMagnitude": 456}}}`)
+	assert.True(t, waitForThrust(456))
+}
@@ -27,6 +27,10 @@ type WebSocketBody struct {
 	Mass      float32
 	Radius    float32
 	NetForce  WebSocketVector
+	// The name and color of the player controlling the body, if any. These
+	// are separate from the celestial names above.
+	PlayerName string `json:",omitempty"`
+	Color      string `json:",omitempty"`
 }
 
 func NewWebSocketBody(body *game.Body) *WebSocketBody {
@@ -46,18 +50,160 @@ func NewWebSocketBody(body *game.Body) *WebSocketBody {
 	}
 }
 
+// WebSocketGameState is the state of the universe at a tick. It's either a
+// keyframe containing every body, or a delta from an earlier state that only
+// contains the bodies that were added or changed since then.
 type WebSocketGameState struct {
+	Tick uint64
+	// If non-zero, this is a delta from the state at this tick.
+	BaseTick uint64 `json:",omitempty"`
+	// The sequence number of the last input from the client that was applied
+	// at or before this tick.
+	InputSeq uint64 `json:",omitempty"`
 	Universe struct {
 		Bounds game.Rect
 		Bodies map[string]*WebSocketBody
+		// The bodies removed since BaseTick.
+		Removed []string `json:",omitempty"`
 	}
 }
 
+// NewWebSocketGameState creates a keyframe for the universe's current state.
+func NewWebSocketGameState(universe *game.Universe) *WebSocketGameState {
+	ret := &WebSocketGameState{
+		Tick: universe.Tick(),
+	}
+	ret.Universe.Bounds = universe.Bounds()
+	ret.Universe.Bodies = NewWebSocketBodies(universe)
+	return ret
+}
+
+func NewWebSocketBodies(universe *game.Universe) map[string]*WebSocketBody {
+	ret := make(map[string]*WebSocketBody)
+	for id, body := range universe.Bodies() {
+		ret[id.String()] = NewWebSocketBody(body)
+	}
+	return ret
+}
+
+// WebSocketPlaybackState describes where a replay is in its recording.
+type WebSocketPlaybackState struct {
+	Tick      uint64
+	StartTick uint64
+	EndTick   uint64
+	Paused    bool
+	Speed     float64
+}
+
+// WebSocketPlayerLeft is sent when a player leaves for good.
+type WebSocketPlayerLeft struct {
+	BodyId string
+}
+
+// WebSocketMatchState is sent when a room's match status or player count
+// changes, and every second during the countdown.
+type WebSocketMatchState struct {
+	Status     MatchStatus
+	Players    int
+	MinPlayers int `json:",omitempty"`
+	// The number of seconds left before the match starts.
+	Countdown int `json:",omitempty"`
+}
+
+// WebSocketSpectatorState is sent to spectators when the body they're
+// following changes.
+type WebSocketSpectatorState struct {
+	BodyId string
+	// If the spectator is following a rank, this is the rank of the body.
+	Rank int `json:",omitempty"`
+}
+
+// WebSocketChatMessage is a chat message sent to players.
+type WebSocketChatMessage struct {
+	Channel ChatChannel
+	// The sender's body id and name. They're empty for system messages.
+	From     string `json:",omitempty"`
+	FromName string `json:",omitempty"`
+	// The recipient's body id, for whispers.
+	To string `json:",omitempty"`
+	// The sender's team, for team messages.
+	Team string `json:",omitempty"`
+	Text string
+}
+
+// WebSocketPing can be sent by either side. The other side answers with a
+// WebSocketPong right away. Times are in milliseconds since the Unix epoch.
+type WebSocketPing struct {
+	Time float64
+}
+
+// WebSocketPong answers a ping. The sender of the ping can use it to work out
+// the round trip time and, assuming the trip takes as long each way, the
+// offset between its clock and the other side's.
+type WebSocketPong struct {
+	// The time in the ping being answered.
+	PingTime float64
+	// The time the ping was answered, by the answerer's clock.
+	Time float64
+}
+
 type WebSocketOutput struct {
 	GameState      *WebSocketGameState `json:",omitempty"`
 	AssignedBodyId string              `json:",omitempty"`
+	// Sent along with AssignedBodyId. Passing it back in the session query
+	// parameter when reconnecting to the same room resumes control of the
+	// same body.
+	SessionToken string `json:",omitempty"`
+	// The name of the room the player joined, also sent with AssignedBodyId.
+	Room       string                   `json:",omitempty"`
+	Playback   *WebSocketPlaybackState  `json:",omitempty"`
+	PlayerLeft *WebSocketPlayerLeft     `json:",omitempty"`
+	Match      *WebSocketMatchState     `json:",omitempty"`
+	Spectating *WebSocketSpectatorState `json:",omitempty"`
+	// New chat messages. Players get the room's recent history when they
+	// join.
+	Chat []*WebSocketChatMessage `json:",omitempty"`
+	Ping *WebSocketPing          `json:",omitempty"`
+	Pong *WebSocketPong          `json:",omitempty"`
+}
+
+// WebSocketPlaybackControl changes the playback of a replay. Only the fields
+// that are set are changed.
+type WebSocketPlaybackControl struct {
+	Paused *bool    `json:",omitempty"`
+	Speed  *float64 `json:",omitempty"`
+	Seek   *uint64  `json:",omitempty"`
+}
+
+// WebSocketSpectateControl changes what a spectator is following. Only one of
+// the fields should be set.
+type WebSocketSpectateControl struct {
+	BodyId string `json:",omitempty"`
+	// A player's name, or a body's major or minor name.
+	Name string `json:",omitempty"`
+	// Follows whichever body is the nth heaviest, starting from 1.
+	Rank int `json:",omitempty"`
+}
+
+// WebSocketChatInput is a chat message sent by a player.
+type WebSocketChatInput struct {
+	Channel ChatChannel
+	// The recipient's body id, for whispers.
+	To   string `json:",omitempty"`
+	Text string
 }
 
 type WebSocketInput struct {
+	// A number the client picks for each input, which should increase with
+	// every input. The server echoes back the last one it's applied in
+	// WebSocketGameState.InputSeq.
+	Seq    uint64       `json:",omitempty"`
 	Thrust *game.Vector `json:",omitempty"`
+	// The latest tick the client has received the game state for.
+	Ack      uint64                    `json:",omitempty"`
+	Playback *WebSocketPlaybackControl `json:",omitempty"`
+	Spectate *WebSocketSpectateControl `json:",omitempty"`
+	Chat     *WebSocketChatInput       `json:",omitempty"`
+	Ping     *WebSocketPing            `json:",omitempty"`
+	Pong     *WebSocketPong            `json:",omitempty"`
 }
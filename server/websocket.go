@@ -1,72 +1,227 @@
 package server
 
 import (
-	"math/rand"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/pkg/errors"
+	"github.com/satori/go.uuid"
 	"github.com/sirupsen/logrus"
 	"github.com/vmrob/grav-game/game"
 )
 
 type WebSocket struct {
-	conn          *websocket.Conn
-	outgoing      chan *WebSocketOutput
+	// Identifies the websocket as the source of its events.
+	id       string
+	conn     *websocket.Conn
+	codec    codec
+	outgoing chan *WebSocketOutput
+	// The latest message sent with SendLatest, which replaces any that haven't
+	// been written yet. latestReady is signaled when it's set.
+	latest      *WebSocketOutput
+	latestMutex sync.Mutex
+	latestReady chan struct{}
+	// The number of messages SendLatest has replaced since the last write.
+	// It's guarded by latestMutex.
+	coalesced int
+	// Closed by Close. Anything sent after that is dropped.
+	closed        chan struct{}
+	closeOnce     sync.Once
 	readLoopDone  chan struct{}
 	writeLoopDone chan struct{}
 	logger        logrus.FieldLogger
-	universe      *game.Universe
-	body          *game.Body
+	handleInput   func(msg *WebSocketInput)
+	room          *Room
+	session       *session
+	// Set if the websocket is a spectator rather than a player.
+	spectator *spectator
+	deltas    deltaEncoder
+	// The last tick acknowledged by the client. It's accessed atomically.
+	ack uint64
+	// The sequence number of the last input applied to the universe. It's
+	// only used by the goroutine that steps the universe.
+	inputSeq uint64
+	// The smoothed round trip time in nanoseconds. It's accessed atomically.
+	latency int64
+	// Game states are sent every this many ticks. It's set by the write
+	// goroutine and accessed atomically.
+	stateInterval int32
+	// The number of ticks since the last game state was sent. It's only used
+	// by the goroutine that steps the universe.
+	ticksSinceState int32
+	disconnectOnce  sync.Once
 }
 
-func NewWebSocket(logger logrus.FieldLogger, conn *websocket.Conn, universe *game.Universe) *WebSocket {
-	bounds := universe.Bounds()
-	ret := &WebSocket{
+// The number of messages other than game states that can be waiting to be
+// sent. Clients that let it fill up are disconnected.
+const outgoingQueueSize = 64
+
+func newWebSocket(logger logrus.FieldLogger, conn *websocket.Conn) *WebSocket {
+	return &WebSocket{
+		id:            uuid.NewV4().String(),
 		conn:          conn,
-		outgoing:      make(chan *WebSocketOutput, 10),
+		codec:         codecForSubprotocol(conn.Subprotocol()),
+		outgoing:      make(chan *WebSocketOutput, outgoingQueueSize),
+		latestReady:   make(chan struct{}, 1),
+		closed:        make(chan struct{}),
 		readLoopDone:  make(chan struct{}),
 		writeLoopDone: make(chan struct{}),
 		logger:        logger,
-		universe:      universe,
-		body: &game.Body{
-			Position: game.Point{X: bounds.X + rand.Float64()*bounds.W, Y: bounds.Y + rand.Float64()*bounds.H},
-			Mass:     game.PlayerStartMass,
-		},
+		stateInterval: 1,
 	}
-	go ret.writeLoop()
-	go ret.readLoop()
+}
 
-	universe.AddEvent(func() {
-		ret.Send(&WebSocketOutput{
-			AssignedBodyId: universe.AddBody(ret.body).String(),
-		})
-	})
+// newPlayerWebSocket creates a websocket for a player in the room. It's given
+// a session by the room before it's started.
+func newPlayerWebSocket(logger logrus.FieldLogger, conn *websocket.Conn, room *Room) *WebSocket {
+	ret := newWebSocket(logger, conn)
+	ret.room = room
+	ret.handleInput = ret.handlePlayerInput
+	return ret
+}
 
+// newSpectatorWebSocket creates a websocket that watches the room's universe
+// without a body of its own.
+func newSpectatorWebSocket(logger logrus.FieldLogger, conn *websocket.Conn, room *Room) *WebSocket {
+	ret := newWebSocket(logger, conn)
+	ret.room = room
+	ret.spectator = newSpectator()
+	ret.handleInput = ret.handleSpectatorInput
 	return ret
 }
 
+// newViewerWebSocket creates a websocket that doesn't control anything in a
+// universe. Any input received from it is passed to handleInput.
+func newViewerWebSocket(logger logrus.FieldLogger, conn *websocket.Conn, handleInput func(msg *WebSocketInput)) *WebSocket {
+	ret := newWebSocket(logger, conn)
+	ret.handleInput = handleInput
+	ret.start()
+	return ret
+}
+
+func (ws *WebSocket) start() {
+	go ws.writeLoop()
+	go ws.readLoop()
+}
+
+// Send queues a message to be sent. Messages are never dropped, so if the
+// client lets too many of them pile up, it's disconnected. Once the websocket
+// is closed, Send does nothing.
 func (ws *WebSocket) Send(msg *WebSocketOutput) {
+	if ws.isClosed() {
+		return
+	}
 	select {
 	case ws.outgoing <- msg:
 	default:
-		ws.logger.Warn("dropping outgoing websocket message")
+		atomic.AddUint64(&droppedMessages, 1)
+		ws.disconnectSlow("outgoing queue is full")
+	}
+}
+
+// SendLatest queues a message that supersedes any earlier ones sent this way,
+// like the state of the universe. If the client is falling behind, the
+// earlier ones are dropped.
+func (ws *WebSocket) SendLatest(msg *WebSocketOutput) {
+	if ws.isClosed() {
+		return
+	}
+	ws.latestMutex.Lock()
+	if ws.latest != nil {
+		ws.coalesced++
+	}
+	ws.latest = msg
+	ws.latestMutex.Unlock()
+
+	select {
+	case ws.latestReady <- struct{}{}:
+	default:
+	}
+}
+
+// Takes the message queued by SendLatest along with the number of messages it
+// replaced.
+func (ws *WebSocket) takeLatest() (*WebSocketOutput, int) {
+	ws.latestMutex.Lock()
+	defer ws.latestMutex.Unlock()
+	msg, coalesced := ws.latest, ws.coalesced
+	ws.latest, ws.coalesced = nil, 0
+	return msg, coalesced
+}
+
+// Closes the connection to a client that can't keep up. The read and write
+// loops stop once the connection is closed, and the websocket gets cleaned up
+// like any other dead one.
+func (ws *WebSocket) disconnectSlow(reason string) {
+	ws.disconnectOnce.Do(func() {
+		atomic.AddUint64(&slowClientDisconnects, 1)
+		ws.logger.WithField("reason", reason).Warn("disconnecting slow client")
+		ws.conn.Close()
+	})
+}
+
+// SendGameState sends the part of the universe's state that's relevant to the
+// player as a delta from the last state they acknowledged. Clients that are
+// falling behind aren't sent a state every tick. It should only be called
+// from the goroutine that steps the universe.
+func (ws *WebSocket) SendGameState(tick uint64, bounds game.Rect, bodies map[string]*WebSocketBody) {
+	ws.ticksSinceState++
+	if ws.ticksSinceState < atomic.LoadInt32(&ws.stateInterval) {
+		return
 	}
+	ws.ticksSinceState = 0
+
+	visible := filterInterest(bodies, ws.focus(bodies))
+	state := ws.deltas.encode(tick, atomic.LoadUint64(&ws.ack), bounds, visible)
+	state.InputSeq = ws.inputSeq
+	ws.SendLatest(&WebSocketOutput{
+		GameState: state,
+	})
+}
+
+// Returns the body the websocket's view is centered on. For players, it's nil
+// if their body hasn't been added yet or it's been removed from the universe.
+func (ws *WebSocket) focus(bodies map[string]*WebSocketBody) *WebSocketBody {
+	if ws.spectator != nil {
+		return bodies[ws.spectator.following]
+	}
+	if !ws.session.hasBody(ws.room.universe) {
+		return nil
+	}
+	return bodies[ws.session.bodyId.String()]
 }
 
 func (ws *WebSocket) IsAlive() bool {
 	select {
 	case <-ws.writeLoopDone:
 		return false
+	case <-ws.readLoopDone:
+		return false
 	default:
 		return true
 	}
 }
 
+func (ws *WebSocket) isClosed() bool {
+	select {
+	case <-ws.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// Close stops the websocket once anything already queued by Send is written.
+// It's safe to keep sending to it from other goroutines while it's closing.
 func (ws *WebSocket) Close() error {
-	close(ws.outgoing)
+	ws.closeOnce.Do(func() {
+		close(ws.closed)
+	})
 	<-ws.readLoopDone
 	<-ws.writeLoopDone
+	ws.logger.WithField("latency", ws.Latency()).Info("websocket closed")
 	return nil
 }
 
@@ -75,20 +230,65 @@ func (ws *WebSocket) writeLoop() {
 
 	defer ws.conn.Close()
 
+	pingTicker := time.NewTicker(pingInterval)
+	defer pingTicker.Stop()
+
+	rate := newSendRate()
+
 	for {
-		msg, ok := <-ws.outgoing
-		if !ok {
+		var msg *WebSocketOutput
+		latest, coalesced := false, 0
+		select {
+		case m := <-ws.outgoing:
+			msg = m
+		case <-ws.closed:
+			// write whatever was queued before the websocket was closed
+			select {
+			case msg = <-ws.outgoing:
+			default:
+				return
+			}
+		case <-ws.latestReady:
+			if msg, coalesced = ws.takeLatest(); msg == nil {
+				continue
+			}
+			latest = true
+		case now := <-pingTicker.C:
+			msg = &WebSocketOutput{
+				Ping: &WebSocketPing{
+					Time: unixMillis(now),
+				},
+			}
+		}
+
+		data, err := ws.codec.EncodeOutput(msg)
+		if err != nil {
+			ws.logger.Error(errors.Wrap(err, "unable to encode websocket message"))
 			break
 		}
 
-		ws.conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+		start := time.Now()
+		ws.conn.SetWriteDeadline(start.Add(5 * time.Second))
 
-		if err := ws.conn.WriteJSON(msg); err != nil {
+		if err := ws.conn.WriteMessage(ws.codec.MessageType(), data); err != nil {
 			if !websocket.IsCloseError(err, websocket.CloseAbnormalClosure, websocket.CloseGoingAway) && err != websocket.ErrCloseSent {
 				ws.logger.Error(errors.Wrap(err, "websocket write error"))
 			}
 			break
 		}
+		atomic.AddUint64(&sentBytes, uint64(len(data)))
+		if ws.room != nil {
+			atomic.AddUint64(&ws.room.sentBytes, uint64(len(data)))
+		}
+
+		if latest {
+			now := time.Now()
+			if !rate.wrote(now, now.Sub(start), coalesced) {
+				ws.disconnectSlow("game states are backed up")
+				break
+			}
+			atomic.StoreInt32(&ws.stateInterval, int32(rate.interval))
+		}
 	}
 }
 
@@ -98,8 +298,7 @@ func (ws *WebSocket) readLoop() {
 	for {
 		ws.conn.SetReadLimit(4 * 1024)
 
-		var msg WebSocketInput
-		err := ws.conn.ReadJSON(&msg)
+		_, data, err := ws.conn.ReadMessage()
 		if err != nil {
 			if !websocket.IsCloseError(err, websocket.CloseAbnormalClosure, websocket.CloseGoingAway) {
 				ws.logger.Error(errors.Wrap(err, "websocket read error"))
@@ -107,8 +306,84 @@ func (ws *WebSocket) readLoop() {
 			return
 		}
 
-		if msg.Thrust != nil {
-			ws.universe.AddEvent(ws.body.ThrustEvent(*msg.Thrust))
+		var msg WebSocketInput
+		if err := ws.codec.DecodeInput(data, &msg); err != nil {
+			ws.logger.Error(errors.Wrap(err, "unable to decode websocket message"))
+			return
+		}
+
+		if msg.Ping != nil {
+			ws.handlePing(msg.Ping)
+		}
+		if msg.Pong != nil {
+			ws.handlePong(msg.Pong)
 		}
+		ws.handleInput(&msg)
+	}
+}
+
+func (ws *WebSocket) handlePlayerInput(msg *WebSocketInput) {
+	if msg.Ack != 0 {
+		atomic.StoreUint64(&ws.ack, msg.Ack)
+	}
+	if msg.Thrust == nil && msg.Chat == nil && msg.Seq == 0 {
+		return
+	}
+
+	thrust, chat := msg.Thrust, msg.Chat
+	universe := ws.room.universe
+	// only the latest thrust matters, so inputs that are just thrust replace
+	// any that haven't been applied yet. inputs with neither, like pings,
+	// still need their sequence numbers acked, but they mustn't replace a
+	// thrust
+	coalesceKey := ""
+	if thrust != nil && chat == nil {
+		coalesceKey = "thrust"
+	}
+	ws.applyInput(msg.Seq, coalesceKey, func() {
+		// the session might have been taken over by a newer connection
+		if thrust != nil && ws.session.ws == ws && ws.session.hasBody(universe) && ws.room.match.status == MatchStarted {
+			universe.SetThrust(ws.session.bodyId, *thrust)
+		}
+		if chat != nil {
+			ws.room.chat(ws, chat)
+		}
+	})
+}
+
+func (ws *WebSocket) handleSpectatorInput(msg *WebSocketInput) {
+	if msg.Ack != 0 {
+		atomic.StoreUint64(&ws.ack, msg.Ack)
+	}
+	if msg.Spectate == nil && msg.Seq == 0 {
+		return
+	}
+
+	control := msg.Spectate
+	ws.applyInput(msg.Seq, "", func() {
+		if control != nil && !ws.spectator.follow(control, ws.room.findBodyByName) {
+			ws.logger.WithField("name", control.Name).Info("spectator asked to follow an unknown body")
+		}
+	})
+}
+
+// Queues f to be run at the start of the next step, after which the input's
+// sequence number counts as processed. Inputs with the same non-empty
+// coalesceKey replace each other until they're applied. If the client is
+// sending input faster than it can be applied, the input is dropped.
+func (ws *WebSocket) applyInput(seq uint64, coalesceKey string, f func()) {
+	err := ws.room.universe.QueueEvent(game.Event{
+		Source:      ws.id,
+		Priority:    game.InputPriority,
+		CoalesceKey: coalesceKey,
+		Apply: func() {
+			f()
+			if seq > ws.inputSeq {
+				ws.inputSeq = seq
+			}
+		},
+	})
+	if err != nil {
+		ws.logger.WithError(err).Warn("dropping input")
 	}
 }
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/vmrob/grav-game/game"
+)
+
+func TestServerInputSeq(t *testing.T) {
+	s := NewServer(logrus.StandardLogger())
+	defer s.Close()
+
+	client, err := newWebsocketConnection(s)
+	require.NoError(t, err)
+	defer client.Close()
+
+	for seq := uint64(1); seq <= 3; seq++ {
+		require.NoError(t, client.WriteJSON(&WebSocketInput{
+			Seq:    seq,
+			Thrust: &game.Vector{X: float64(seq)},
+		}))
+	}
+
+	var lastTick, lastSeq uint64
+	for i := 0; lastSeq < 3 && i < 100; i++ {
+		var msg WebSocketOutput
+		require.NoError(t, client.ReadJSON(&msg))
+		if msg.GameState == nil {
+			continue
+		}
+		assert.True(t, msg.GameState.Tick > lastTick)
+		assert.True(t, msg.GameState.InputSeq >= lastSeq)
+		lastTick, lastSeq = msg.GameState.Tick, msg.GameState.InputSeq
+	}
+	assert.Equal(t, uint64(3), lastSeq)
+}
+
+// Waits for a websocket to connect to the room and returns it.
+func findWebSocket(t *testing.T, room *Room) *WebSocket {
+	for i := 0; i < 100; i++ {
+		room.webSocketsMutex.Lock()
+		for ws := range room.webSockets {
+			room.webSocketsMutex.Unlock()
+			return ws
+		}
+		room.webSocketsMutex.Unlock()
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("timed out waiting for a websocket")
+	return nil
+}
+
+func TestWebSocketSendAfterClose(t *testing.T) {
+	s := NewServer(logrus.StandardLogger())
+	defer s.Close()
+
+	client, err := newWebsocketConnection(s)
+	require.NoError(t, err)
+	defer client.Close()
+	ws := findWebSocket(t, s.defaultRoom)
+
+	require.NoError(t, ws.Close())
+	disconnects := SlowClientDisconnects()
+	// enough to fill the queue if the sends weren't ignored
+	for i := 0; i < outgoingQueueSize*2; i++ {
+		ws.Send(&WebSocketOutput{})
+		ws.SendLatest(&WebSocketOutput{})
+	}
+	assert.Equal(t, disconnects, SlowClientDisconnects())
+}
+
+func TestPingDoesNotReplaceThrust(t *testing.T) {
+	universe := DefaultRoomConfig().newUniverse()
+	room := &Room{universe: universe}
+	room.match.status = MatchStarted
+	ws := &WebSocket{
+		id:     "player",
+		room:   room,
+		logger: logrus.StandardLogger(),
+	}
+	ws.session = &session{
+		body: &game.Body{Mass: game.PlayerStartMass},
+		ws:   ws,
+	}
+	ws.session.bodyId = universe.AddBody(ws.session.body)
+
+	// clients put sequence numbers on everything, pings included
+	ws.handlePlayerInput(&WebSocketInput{Seq: 1, Thrust: &game.North})
+	ws.handlePlayerInput(&WebSocketInput{Seq: 2, Ping: &WebSocketPing{Time: 1}})
+	universe.ApplyEvents()
+
+	assert.Equal(t, game.North, ws.session.body.ThrustDirection)
+	assert.Equal(t, uint64(2), ws.inputSeq)
+}